@@ -0,0 +1,39 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"github.com/bvarner/pi-launch-control/analysis"
+)
+
+// Calibrated thrust from each sample, combined across channels, in newtons. Uncalibrated samples are skipped.
+// Unless filtered, thrust comes from the raw counts of samples taken through a scale filter.
+func ThrustPoints(samples []Sample, filtered bool) []analysis.Point {
+	points := make([]analysis.Point, 0, len(samples))
+	for _, s := range samples {
+		if !filtered {
+			s = s.Unfiltered()
+		}
+		// Samples recorded before channels were combined only have channel 0, in grams.
+		if s.Mass == nil {
+			s.Mass = s.Volt0Mass
+		}
+		s.calculateThrust()
+		if s.Newtons != nil {
+			points = append(points, analysis.Point {
+				Time: 	s.Timestamp,
+				Thrust: *s.Newtons,
+			})
+		}
+	}
+	return points
+}
+
+// Analyzes the thrust curve in the recorded samples, filtered or not.
+func AnalyzeSamples(samples []Sample, filtered bool) (analysis.Result, error) {
+	return analysis.Analyze(ThrustPoints(samples, filtered), analysis.DefaultConfig())
+}
+
+// The analysis as an analysis.json archive entry.
+func AnalysisArchiveFile(result *analysis.Result) (map[*zip.FileHeader][]byte, error) {
+	return jsonArchiveFile("analysis.json", result)
+}
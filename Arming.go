@@ -0,0 +1,142 @@
+package pi_launch_control
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/host"
+	"sync"
+	"time"
+)
+
+// Representation of Arming state.
+//
+// swagger:model
+type ArmingState struct {
+	// The physical key switch is on.
+	KeyArmed		bool
+	// An operator has armed through the API.
+	SoftwareArmed	bool
+	// Both of the above.
+	Armed			bool
+	Timestamp		int64
+}
+
+// Two-step arming: a keyed switch on a GPIO, and a software arm which requires the key.
+type Arming struct {
+	KeyPin			gpio.PinIO	`json:"-"`
+
+	softwareArmed	bool
+	// Time left on the igniter's lockout, nil until an igniter uses this Arming.
+	lockout			func() time.Duration
+
+	Emitter					`json:"-"`
+	sync.Mutex				`json:"-"`
+}
+
+func NewArming(keyPinName string) (*Arming, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+
+	keyPin := gpioreg.ByName(keyPinName)
+	if keyPin == nil {
+		return nil, fmt.Errorf("no such gpio: %s", keyPinName)
+	}
+	return NewArmingFromPin(keyPin)
+}
+
+// Creates Arming reading the key switch from the given pin.
+func NewArmingFromPin(keyPin gpio.PinIO) (*Arming, error) {
+	a := &Arming {
+		KeyPin: keyPin,
+	}
+	a.EmitterID = a
+
+	// Like the igniter test circuit, the key switch sinks to ground. Interrupt on both edges.
+	err := a.KeyPin.In(gpio.PullUp, gpio.BothEdges)
+	if err == nil {
+		go func() {
+			for {
+				a.KeyPin.WaitForEdge(-1)
+				a.Lock()
+				// Turning the key off always safes the system.
+				if !a.KeyArmed() {
+					a.softwareArmed = false
+				}
+				a.Unlock()
+				a.Emit(a.GetState())
+			}
+		}()
+	} else {
+		log.Print(err)
+	}
+
+	return a, err
+}
+
+func (a *Arming) eventName() string {
+	return "Arming"
+}
+
+func (a *Arming) KeyArmed() bool {
+	return a.KeyPin.Read() == gpio.Low
+}
+
+// Software arm. The key must already be on, and nobody re-arms until a hangfire lockout has expired.
+func (a *Arming) Arm() error {
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.KeyArmed() {
+		return errors.New("arming key is not on")
+	}
+	if a.lockout != nil {
+		if remaining := a.lockout(); remaining > 0 {
+			return fmt.Errorf("igniter locked out for %.0f seconds", remaining.Seconds())
+		}
+	}
+	if !a.softwareArmed {
+		a.softwareArmed = true
+		a.Emit(a.getState())
+	}
+	return nil
+}
+
+// Software safe.
+func (a *Arming) Safe() {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.softwareArmed {
+		a.softwareArmed = false
+		a.Emit(a.getState())
+	}
+}
+
+// True only if both the key and the software are armed.
+func (a *Arming) IsArmed() bool {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.softwareArmed && a.KeyArmed()
+}
+
+func (a *Arming) GetState() ArmingState {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.getState()
+}
+
+func (a *Arming) getState() ArmingState {
+	key := a.KeyArmed()
+	return ArmingState {
+		KeyArmed: 		key,
+		SoftwareArmed: 	a.softwareArmed,
+		Armed: 			key && a.softwareArmed,
+		Timestamp: 		time.Now().Unix(),
+	}
+}
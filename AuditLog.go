@@ -0,0 +1,171 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Request bodies larger than this aren't recorded, ie: uploaded replay archives.
+const auditMaxBody = 64 * 1024
+
+// A control action taken by an operator.
+//
+// swagger:model
+type AuditEntry struct {
+	// Unix nanoseconds the request was received.
+	Timestamp		int64
+	Method			string
+	Action			string
+	// Query parameters, and the request body if it was JSON.
+	Parameters		map[string]string
+	Body			json.RawMessage	`json:",omitempty"`
+	// Remote address of the client.
+	Client			string
+	// Client certificate common name, or basic auth user, when available.
+	Identity		string
+	// HTTP status of the response.
+	Status			int
+	// ID of the mission underway, if any.
+	Mission			string
+}
+
+// Append-only log of AuditEntry, stored as one JSON object per line.
+type AuditLog struct {
+	Path			string
+
+	Emitter					`json:"-"`
+	sync.Mutex				`json:"-"`
+
+	file			*os.File
+	entries			[]AuditEntry
+}
+
+// Opens (or creates) the audit log at path, loading any existing entries.
+func NewAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	a := &AuditLog {
+		Path: 		path,
+		entries: 	make([]AuditEntry, 0),
+	}
+	a.EmitterID = a
+
+	f, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE | os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, auditMaxBody), auditMaxBody * 4)
+	for scanner.Scan() {
+		var entry AuditEntry
+		// Skip anything torn by a power loss.
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			a.entries = append(a.entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	a.file = f
+	return a, nil
+}
+
+func (a *AuditLog) eventName() string {
+	return "Audit"
+}
+
+// Starts an entry for the request, restoring the body for the handler.
+func NewAuditEntry(r *http.Request) AuditEntry {
+	entry := AuditEntry {
+		Timestamp: 	time.Now().UnixNano(),
+		Method: 	r.Method,
+		Action: 	r.URL.Path,
+		Parameters: make(map[string]string),
+		Client: 	r.RemoteAddr,
+	}
+
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			entry.Parameters[k] = v[0]
+		}
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		entry.Identity = r.TLS.PeerCertificates[0].Subject.CommonName
+	} else if user, _, ok := r.BasicAuth(); ok {
+		entry.Identity = user
+	}
+
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, auditMaxBody + 1))
+		if err == nil && len(body) <= auditMaxBody && json.Valid(body) {
+			entry.Body = body
+		}
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	}
+
+	return entry
+}
+
+// Appends the entry to the log.
+func (a *AuditLog) Record(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if _, err = a.file.Write(append(b, '\n')); err == nil {
+		err = a.file.Sync()
+	}
+	a.Emit(entry)
+	return err
+}
+
+// Entries received between since and until (unix nanoseconds, 0 for no limit), optionally limited to an action
+// and mission.
+func (a *AuditLog) Query(since int64, until int64, action string, mission string) []AuditEntry {
+	a.Lock()
+	defer a.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	for _, e := range a.entries {
+		if (since > 0 && e.Timestamp < since) || (until > 0 && e.Timestamp > until) {
+			continue
+		}
+		if (action != "" && e.Action != action) || (mission != "" && e.Mission != mission) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// The actions taken during a mission, as an audit.json archive entry.
+func (a *AuditLog) ArchiveFile(mission string) (map[*zip.FileHeader][]byte, error) {
+	return jsonArchiveFile("audit.json", a.Query(0, 0, "", mission))
+}
+
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.file.Close()
+}
@@ -0,0 +1,202 @@
+package pi_launch_control
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// How well a single calibration point agrees with the fit.
+//
+// swagger:model
+type CalibrationResidual struct {
+	Mass			int
+	// Counts above the tare reading.
+	Measured		int
+	Predicted		float64
+	// Measured less predicted, in counts and in mass units.
+	Residual		float64
+	ResidualMass	float64
+}
+
+// A least squares fit of counts above the tare reading against mass:
+//
+//   counts = Slope * mass + Quadratic * mass²
+//
+// The fit passes through the tare reading, since that's the zero counts are converted to mass from. So that a bad tare
+// can still be seen, Intercept is where a fit of the loaded points alone, free to miss the tare, puts zero mass.
+//
+// swagger:model
+type CalibrationFit struct {
+	// 1 for a straight line, 2 to include the Quadratic term.
+	Order			int
+	Slope			float64
+	Quadratic		float64
+	// Counts above the tare reading at zero mass, fitting only the loaded points. Needs a loaded point more than
+	// the fit's Order.
+	Intercept		*float64	`json:",omitempty"`
+	RSquared		float64
+	Residuals		[]CalibrationResidual
+	// Largest residual, as a percentage of the largest calibrated output.
+	MaxNonlinearity	float64
+}
+
+// Fits the known measured values, by mass, relative to the zero offset.
+func FitCalibration(measured map[int]int, zero int, order int) (CalibrationFit, error) {
+	fit := CalibrationFit{ Order: order }
+	if order != 1 && order != 2 {
+		return fit, errors.New("calibration fit order must be 1 or 2")
+	}
+
+	masses := make([]int, 0, len(measured))
+	for mass := range measured {
+		masses = append(masses, mass)
+	}
+	sort.Ints(masses)
+	loaded := 0
+	for _, mass := range masses {
+		if mass != 0 {
+			loaded++
+		}
+	}
+	if loaded < order {
+		return fit, errors.New("not enough calibration points for the fit")
+	}
+
+	x := make([]float64, len(masses))
+	y := make([]float64, len(masses))
+	for idx, mass := range masses {
+		x[idx] = float64(mass)
+		y[idx] = float64(measured[mass] - zero)
+	}
+
+	// Mass and mass², with no constant term.
+	coefficients, err := leastSquares(x, y, 1, order)
+	if err != nil {
+		return fit, err
+	}
+	fit.Slope = coefficients[0]
+	if order == 2 {
+		fit.Quadratic = coefficients[1]
+	}
+	if fit.Slope == 0 {
+		return fit, errors.New("calibration points show no change with mass")
+	}
+
+	// The same, with a constant term, over the loaded points.
+	if loaded > order {
+		lx := make([]float64, 0, loaded)
+		ly := make([]float64, 0, loaded)
+		for idx := range x {
+			if x[idx] != 0 {
+				lx = append(lx, x[idx])
+				ly = append(ly, y[idx])
+			}
+		}
+		if free, err := leastSquares(lx, ly, 0, order); err == nil {
+			fit.Intercept = &free[0]
+		}
+	}
+
+	var mean, full float64
+	for idx := range y {
+		mean += y[idx]
+		full = math.Max(full, math.Abs(y[idx]))
+	}
+	mean /= float64(len(y))
+
+	var residual, total, worst float64
+	fit.Residuals = make([]CalibrationResidual, len(masses))
+	for idx, mass := range masses {
+		predicted := fit.Slope * x[idx] + fit.Quadratic * x[idx] * x[idx]
+		r := CalibrationResidual {
+			Mass: 		mass,
+			Measured: 	int(y[idx]),
+			Predicted: 	predicted,
+			Residual: 	y[idx] - predicted,
+		}
+		r.ResidualMass = r.Residual / fit.slopeAt(x[idx])
+		fit.Residuals[idx] = r
+
+		residual += r.Residual * r.Residual
+		total += (y[idx] - mean) * (y[idx] - mean)
+		worst = math.Max(worst, math.Abs(r.Residual))
+	}
+	if total > 0 {
+		fit.RSquared = 1 - residual / total
+	}
+	if full > 0 {
+		fit.MaxNonlinearity = worst / full * 100
+	}
+
+	return fit, nil
+}
+
+// Counts per mass unit at the given mass.
+func (fit CalibrationFit) slopeAt(mass float64) float64 {
+	return fit.Slope + 2 * fit.Quadratic * mass
+}
+
+// Converts counts above the tare reading to mass, using a slope (Adjust) and optional quadratic term.
+func countsToMass(counts float64, adjust float64, quadratic float64) float64 {
+	if quadratic != 0 {
+		// Positive root of quadratic * m² + adjust * m - counts = 0
+		discriminant := adjust * adjust + 4 * quadratic * counts
+		if discriminant >= 0 {
+			return (-adjust + math.Sqrt(discriminant)) / (2 * quadratic)
+		}
+	}
+	return counts / adjust
+}
+
+// Least squares coefficients of y against the powers of x from low to high.
+func leastSquares(x []float64, y []float64, low int, high int) ([]float64, error) {
+	size := high - low + 1
+	a := make([][]float64, size)
+	for row := range a {
+		a[row] = make([]float64, size + 1)
+		for col := 0; col < size; col++ {
+			for idx := range x {
+				a[row][col] += math.Pow(x[idx], float64(row + col + 2 * low))
+			}
+		}
+		for idx := range x {
+			a[row][size] += math.Pow(x[idx], float64(row + low)) * y[idx]
+		}
+	}
+	return solve(a)
+}
+
+// Gaussian elimination with partial pivoting over an augmented matrix.
+func solve(a [][]float64) ([]float64, error) {
+	n := len(a)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errors.New("calibration points don't determine a fit, use more distinct masses")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for row := col + 1; row < n; row++ {
+			factor := a[row][col] / a[col][col]
+			for c := col; c <= n; c++ {
+				a[row][c] -= factor * a[col][c]
+			}
+		}
+	}
+
+	result := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := a[row][n]
+		for c := row + 1; c < n; c++ {
+			sum -= a[row][c] * result[c]
+		}
+		result[row] = sum / a[row][row]
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package pi_launch_control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitCalibrationLinear(t *testing.T) {
+	zero := 100000
+	measured := map[int]int{ 0: zero }
+	for _, mass := range []int{ 100, 200, 500, 1000 } {
+		measured[mass] = zero + int(42.5 * float64(mass))
+	}
+
+	fit, err := FitCalibration(measured, zero, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(fit.Slope - 42.5) > 0.01 {
+		t.Fatalf("slope %f, expected 42.5", fit.Slope)
+	}
+	for _, r := range fit.Residuals {
+		if got := countsToMass(float64(r.Measured), fit.Slope, fit.Quadratic); math.Abs(got - float64(r.Mass)) > 0.05 {
+			t.Fatalf("%d converts to %f", r.Mass, got)
+		}
+	}
+}
+
+func TestFitCalibrationQuadratic(t *testing.T) {
+	zero := 5000
+	measured := map[int]int{ 0: zero }
+	for _, mass := range []int{ 100, 250, 500, 750, 1000 } {
+		m := float64(mass)
+		measured[mass] = zero + int(math.Round(40 * m + 0.01 * m * m))
+	}
+
+	fit, err := FitCalibration(measured, zero, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(fit.Slope - 40) > 0.01 || math.Abs(fit.Quadratic - 0.01) > 0.0001 {
+		t.Fatalf("slope %f quadratic %f, expected 40 and 0.01", fit.Slope, fit.Quadratic)
+	}
+	if fit.RSquared < 0.9999 {
+		t.Fatalf("R² %f", fit.RSquared)
+	}
+}
+
+// The residuals describe the conversion counts are actually put through.
+func TestFitCalibrationResidualsMatchConversion(t *testing.T) {
+	zero := 100000
+	measured := map[int]int {
+		0: 		zero,
+		100: 	zero + 4300,
+		500: 	zero + 21200,
+		1000: 	zero + 42600,
+	}
+
+	fit, err := FitCalibration(measured, zero, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range fit.Residuals {
+		converted := countsToMass(float64(r.Measured), fit.Slope, fit.Quadratic)
+		if math.Abs((converted - float64(r.Mass)) - r.ResidualMass) > 1e-6 {
+			t.Fatalf("%d converts to %f, but its residual is %f", r.Mass, converted, r.ResidualMass)
+		}
+	}
+	if fit.Residuals[0].Mass != 0 || fit.Residuals[0].Residual != 0 {
+		t.Fatalf("fit doesn't pass through the tare: %+v", fit.Residuals[0])
+	}
+}
+
+func TestFitCalibrationNeedsPoints(t *testing.T) {
+	if _, err := FitCalibration(map[int]int{ 0: 100, 500: 20000 }, 100, 2); err == nil {
+		t.Fatal("fit a quadratic to one loaded point")
+	}
+}
+
+// A tare taken with something on the scale shows up in the intercept, though the fit still passes through it.
+func TestFitCalibrationReportsIntercept(t *testing.T) {
+	zero := 100000
+	measured := map[int]int{ 0: zero - 850 }
+	for _, mass := range []int{ 100, 500, 1000 } {
+		measured[mass] = zero + int(42.5 * float64(mass))
+	}
+
+	fit, err := FitCalibration(measured, zero - 850, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fit.Intercept == nil || math.Abs(*fit.Intercept - 850) > 0.5 {
+		t.Fatalf("intercept %v, expected 850", fit.Intercept)
+	}
+	if fit.Residuals[0].Residual != 0 {
+		t.Fatalf("fit doesn't pass through the tare: %+v", fit.Residuals[0])
+	}
+
+	// One loaded point can't say where zero is.
+	fit, err = FitCalibration(map[int]int{ 0: zero, 500: zero + 21250 }, zero, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fit.Intercept != nil {
+		t.Fatalf("intercept %f from one point", *fit.Intercept)
+	}
+}
@@ -18,8 +18,7 @@ type Camera struct {
 	sync.Mutex		`json:"-"`
 	Recordable		`json:"-"`
 	DeviceName		string
-	device 			*webcam.Webcam
-	pixelFormat 	webcam.PixelFormat
+	source			FrameSource
 	trigger			<-chan time.Time
 
 	// Map of clients. Keys = channels over which we can push direct to attached client.
@@ -39,15 +38,55 @@ type Camera struct {
 	// Filename / then byte buffer.
 	recordedFrames 	map[int64][]byte
 
+	// When the last frame was captured.
+	lastFrame		time.Time
+
+	// Frames captured in the last preTrigger, while not recording.
+	preTrigger		time.Duration
+	history			[]timedFrame
+
 	Initialized 	bool
 	Recording   	bool
 }
 
+type timedFrame struct {
+	when			time.Time
+	frame			[]byte
+}
+
 const FORMAT_MJPG = webcam.PixelFormat((uint32(byte('M'))) | (uint32(byte('J')) << 8) | (uint32(byte('P')) << 16) | (uint32(byte('G')) << 24))
 
 func NewCamera(dev string, trigger <- chan time.Time) (*Camera, error) {
-	var err error = nil
+	c := newCamera(dev, trigger)
+
+	src, err := NewV4L2FrameSource(dev)
+	if err != nil {
+		return c, err
+	}
 
+	return c, c.start(src)
+}
+
+// Creates a Camera capturing from an arbitrary FrameSource.
+func NewCameraFromSource(name string, src FrameSource, trigger <- chan time.Time) (*Camera, error) {
+	c := newCamera(name, trigger)
+	return c, c.start(src)
+}
+
+// Creates a Camera looping the frames in a directory or mission archive. Like NewCamera, the Camera is returned
+// uninitialized if the frames can't be loaded.
+func NewFileCamera(path string, trigger <- chan time.Time) (*Camera, error) {
+	c := newCamera(path, trigger)
+
+	src, err := NewFileFrameSource(path)
+	if err != nil {
+		return c, err
+	}
+
+	return c, c.start(src)
+}
+
+func newCamera(dev string, trigger <- chan time.Time) *Camera {
 	c := new(Camera)
 	c.EmitterID = c
 	c.trigger = trigger
@@ -61,40 +100,31 @@ func NewCamera(dev string, trigger <- chan time.Time) (*Camera, error) {
 	c.Initialized = false
 	c.Recording = false
 
-	c.device, err = webcam.Open(dev)
-	if err != nil {
-		return c, err
-	}
-
-	// Detect capabilities
-	for f := range c.device.GetSupportedFormats() {
-		if f == FORMAT_MJPG {
-			c.pixelFormat = f
-		}
-	}
-
-	// Setup capture format
-	_, _, _, err = c.device.SetImageFormat(c.pixelFormat, 640, 480)
-	if err != nil {
-		return c, err
-	}
+	return c
+}
 
-	// Stuff the device into Streaming mode.
-	c.device.SetBufferCount(1)
-	err = c.device.StartStreaming()
-	if err != nil {
-		return c, err
-	}
+func (c *Camera) start(src FrameSource) error {
+	c.source = src
 
 	// Setup the trigger.
 	go c.frameTrigger()
 
 	go c.clientBroadcast()
 
+	c.Lock()
 	c.Initialized = true;
 	c.Recording = false;
+	c.Unlock()
+
+	return nil
+}
+
+// True while the camera is capturing.
+func (c *Camera) IsInitialized() bool {
+	c.Lock()
+	defer c.Unlock()
 
-	return c, err
+	return c.Initialized
 }
 
 func (c *Camera) eventName() string {
@@ -105,9 +135,11 @@ func (c *Camera) Close() {
 	c.Lock()
 	defer c.Unlock()
 
-	c.StopRecording()
+	c.Recording = false
 	c.Initialized = false
-	c.device.Close()
+	if c.source != nil {
+		c.source.Close()
+	}
 	c.Emit(c)
 }
 
@@ -118,6 +150,16 @@ func (c *Camera) StartRecording() {
 		c.Recording = false
 		c.recordedFrames = nil
 		c.recordedFrames = make(map[int64][]byte)
+
+		// Seed the recording from the frames captured before it.
+		since := time.Now().Add(-c.preTrigger)
+		for _, f := range c.history {
+			if !f.when.Before(since) {
+				c.recordedFrames[f.when.UnixNano()] = f.frame
+			}
+		}
+		c.history = nil
+
 		// Allow other threads to start stuffing things into the array.
 		c.Recording = true
 
@@ -142,6 +184,17 @@ func (c *Camera) ResetRecording() {
 	c.recordedFrames = make(map[int64][]byte)
 }
 
+// Sets how far back recordings reach into the frames captured before StartRecording.
+func (c *Camera) SetPreTrigger(window time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.preTrigger = clampPreTrigger(window)
+	if c.preTrigger == 0 {
+		c.history = nil
+	}
+}
+
 func (c *Camera) GetRecordedData() map[*zip.FileHeader][]byte {
 	c.Lock()
 	defer c.Unlock()
@@ -162,22 +215,29 @@ func (c *Camera) GetRecordedData() map[*zip.FileHeader][]byte {
 func (c *Camera) frameTrigger() {
 	i := 0
 	for when := range c.trigger {
-		buf, idx, err := c.device.GetFrame()
+		frame, err := c.source.GetFrame()
 		if err == nil {
-			// In single buffer mode we need to copy it.
-			// Otherwise, you have to make enough buffers than you can send and re-queue fast enough
-			// to not corrupt the mmaped data in the frames.
-			// With 256 buffers, there are artifacts in the 640x480 feed at 80hz.
-			frame := make([]byte, len(buf))
-			copy(frame, buf)
-			c.device.ReleaseFrame(idx)
-
-			if i == 0 {
+			c.Lock()
+			c.lastFrame = when
+			recording := c.Recording
+			if !recording && c.preTrigger > 0 {
+				c.history = append(c.history, timedFrame{ when, frame })
+				// Forget frames which have fallen out of the window.
+				since := when.Add(-c.preTrigger)
+				drop := 0
+				for drop < len(c.history) && c.history[drop].when.Before(since) {
+					drop++
+				}
+				c.history = c.history[drop:]
+			}
+			c.Unlock()
+
+			if i == 0 && !c.Muted() {
 				// Only stream when we hit a 0
 				c.broadcast <- frame
 			}
 
-			if c.Recording {
+			if recording {
 				go func(camera *Camera, frame []byte, t time.Time) {
 					camera.Lock()
 					defer camera.Unlock()
@@ -197,6 +257,21 @@ func (c *Camera) frameTrigger() {
 	}
 }
 
+// When the last frame was captured from the source.
+func (c *Camera) LastFrame() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lastFrame
+}
+
+// Streams a frame from somewhere other than the camera (a replay) to connected clients.
+func (c *Camera) ReplayFrame(frame []byte) {
+	if c.Initialized {
+		c.broadcast <- frame
+	}
+}
+
 func (c *Camera) clientBroadcast() {
 	for {
 		select {
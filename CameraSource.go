@@ -0,0 +1,74 @@
+package pi_launch_control
+
+import (
+	"github.com/blackjack/webcam"
+)
+
+// A FrameSource supplies JPEG frames to a Camera.
+//
+// GetFrame() is called once per Camera trigger and must return a buffer the caller owns.
+type FrameSource interface {
+	GetFrame() ([]byte, error)
+	Close() error
+}
+
+// FrameSource backed by a V4L2 device producing MJPG.
+type V4L2FrameSource struct {
+	device 			*webcam.Webcam
+	pixelFormat 	webcam.PixelFormat
+}
+
+func NewV4L2FrameSource(dev string) (*V4L2FrameSource, error) {
+	var err error = nil
+
+	src := new(V4L2FrameSource)
+	src.device, err = webcam.Open(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detect capabilities
+	for f := range src.device.GetSupportedFormats() {
+		if f == FORMAT_MJPG {
+			src.pixelFormat = f
+		}
+	}
+
+	// Setup capture format
+	_, _, _, err = src.device.SetImageFormat(src.pixelFormat, 640, 480)
+	if err != nil {
+		src.device.Close()
+		return nil, err
+	}
+
+	// Stuff the device into Streaming mode.
+	src.device.SetBufferCount(1)
+	err = src.device.StartStreaming()
+	if err != nil {
+		src.device.Close()
+		return nil, err
+	}
+
+	return src, nil
+}
+
+func (src *V4L2FrameSource) GetFrame() ([]byte, error) {
+	buf, idx, err := src.device.GetFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	// In single buffer mode we need to copy it.
+	// Otherwise, you have to make enough buffers than you can send and re-queue fast enough
+	// to not corrupt the mmaped data in the frames.
+	// With 256 buffers, there are artifacts in the 640x480 feed at 80hz.
+	frame := make([]byte, len(buf))
+	copy(frame, buf)
+	src.device.ReleaseFrame(idx)
+
+	return frame, nil
+}
+
+func (src *V4L2FrameSource) Close() error {
+	return src.device.Close()
+}
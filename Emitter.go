@@ -3,6 +3,7 @@ package pi_launch_control
 import (
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 )
 
 type EmitterID interface {
@@ -11,9 +12,24 @@ type EmitterID interface {
 
 type Emitter struct {
 	listeners []chan string
+	// Non-zero while muted. Set from request handlers while emitting from device goroutines, so accessed atomically.
+	muted     int32
 	EmitterID
 }
 
+// Suppresses (or resumes) emitting events, such as while a mission replay is playing.
+func (e *Emitter) Mute(muted bool) {
+	var v int32 = 0
+	if muted {
+		v = 1
+	}
+	atomic.StoreInt32(&e.muted, v)
+}
+
+func (e *Emitter) Muted() bool {
+	return atomic.LoadInt32(&e.muted) != 0
+}
+
 func (e *Emitter) AddListener(ch chan string) {
 	if e.listeners == nil {
 		e.listeners = make([]chan string, 0)
@@ -31,6 +47,9 @@ func (e *Emitter) RemoveListener(event string, ch chan string) {
 }
 
 func (e *Emitter) Emit(v interface{}) {
+	if e.Muted() {
+		return
+	}
 	b, err := json.Marshal(v)
 	if err == nil {
 		for _, handler := range e.listeners {
@@ -0,0 +1,42 @@
+package pi_launch_control
+
+import (
+	"sync"
+	"testing"
+)
+
+type testEmitterID struct{}
+
+func (testEmitterID) eventName() string {
+	return "test"
+}
+
+// Replays mute device emitters from a request handler while the devices keep emitting.
+func TestEmitterMuteWhileEmitting(t *testing.T) {
+	e := &Emitter{ EmitterID: testEmitterID{} }
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			e.Emit(i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			e.Mute(i % 2 == 0)
+		}
+	}()
+	wg.Wait()
+
+	e.Mute(true)
+	if !e.Muted() {
+		t.Fatal("not muted")
+	}
+	e.Mute(false)
+	if e.Muted() {
+		t.Fatal("still muted")
+	}
+}
@@ -2,8 +2,10 @@ package pi_launch_control
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpioreg"
@@ -20,6 +22,7 @@ type IgniterState struct {
 	Firing		bool
 	Recording 	bool
 	Timestamp	int64
+	LockedOut	bool
 }
 
 /* How we communicate with the Igniter */
@@ -29,23 +32,47 @@ type Igniter struct {
 
 	firing		bool
 	Recording 	bool
+	lockoutUntil	time.Time
+	arming		*Arming
+	// Where lockouts are timed from. Nil is the wall clock.
+	timeSource	TimeSource
 
 	Emitter 				`json:"-"`
 	Recordable				`json:"-"`
 	sync.Mutex				`json:"-"`
 
 	recordedState	[]IgniterState
+
+	// States emitted in the last preTrigger, while not recording.
+	preTrigger		time.Duration
+	history			[]IgniterState
 }
 
 func NewIgniter(testPinName string, firePinName string)(*Igniter, error) {
 	var err error = nil;
 	if _, err = host.Init(); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	testPin := gpioreg.ByName(testPinName)
+	if testPin == nil {
+		return nil, fmt.Errorf("no such gpio: %s", testPinName)
 	}
+	firePin := gpioreg.ByName(firePinName)
+	if firePin == nil {
+		return nil, fmt.Errorf("no such gpio: %s", firePinName)
+	}
+
+	return NewIgniterFromPins(testPin, firePin)
+}
+
+// Creates an Igniter using the given continuity test and fire pins.
+func NewIgniterFromPins(testPin gpio.PinIO, firePin gpio.PinIO)(*Igniter, error) {
+	var err error = nil;
 
 	i := &Igniter{
-		TestPin: gpioreg.ByName(testPinName),
-		FirePin: gpioreg.ByName(firePinName),
+		TestPin: testPin,
+		FirePin: firePin,
 	}
 	i.EmitterID = i
 
@@ -81,12 +108,21 @@ func (i *Igniter) ResetRecording() {
 
 func (i *Igniter) StartRecording() {
 	i.Lock()
-	defer i.Unlock()
-
 	i.Recording = false
 	i.recordedState = nil
 	i.recordedState = make([]IgniterState, 0)
+
+	// Seed the recording from the states emitted before it. States are stamped in seconds.
+	since := time.Now().Add(-i.preTrigger).Unix()
+	for _, state := range i.history {
+		if state.Timestamp >= since {
+			i.recordedState = append(i.recordedState, state)
+		}
+	}
+	i.history = nil
+
 	i.Recording = true
+	i.Unlock()
 
 	i.Emit(i.GetState())
 }
@@ -94,20 +130,35 @@ func (i *Igniter) StartRecording() {
 
 func (i *Igniter) StopRecording() {
 	i.Lock()
-	defer i.Unlock()
 	i.Recording = false
+	i.Unlock()
 
 	i.Emit(i.GetState())
 }
 
+// Sets how far back recordings reach into the states emitted before StartRecording.
+func (i *Igniter) SetPreTrigger(window time.Duration) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.preTrigger = clampPreTrigger(window)
+	if i.preTrigger == 0 {
+		i.history = nil
+	}
+}
+
 func (i *Igniter) GetRecordedData() map[*zip.FileHeader][]byte {
 	i.Lock()
 	defer i.Unlock()
 
 	files := make(map[*zip.FileHeader][]byte)
+	if len(i.recordedState) == 0 {
+		return files
+	}
+
 	header := &zip.FileHeader {
 		Name:   "igniter.json",
-		Modified: time.Unix(0, i.recordedState[0].Timestamp),
+		Modified: time.Unix(i.recordedState[0].Timestamp, 0),
 		Method: zip.Deflate,
 	}
 
@@ -116,19 +167,76 @@ func (i *Igniter) GetRecordedData() map[*zip.FileHeader][]byte {
 }
 
 func (i *Igniter) GetFirstRecorded() *IgniterState {
-	if i.recordedState != nil {
+	i.Lock()
+	defer i.Unlock()
+
+	if len(i.recordedState) > 0 {
 		return &(i.recordedState[0])
 	}
 	return nil
 }
 
 func (i *Igniter) GetState() IgniterState {
+	i.Lock()
+	firing, recording := i.firing, i.Recording
+	i.Unlock()
+
 	return IgniterState{
 		i.IsReady(),
-		i.IsFiring() || i.firing,
-		i.Recording,
+		i.IsFiring() || firing,
+		recording,
 		time.Now().Unix(),
+		i.LockedOut(),
+	}
+}
+
+// Sets where lockouts are timed from, so they run on the same clock as the Mission which imposes them.
+func (i *Igniter) SetTimeSource(ts TimeSource) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.timeSource = ts
+}
+
+// Must be called with the lock held.
+func (i *Igniter) now() time.Time {
+	if i.timeSource == nil {
+		return time.Now()
 	}
+	return i.timeSource.Now()
+}
+
+// Disables the fire circuit for d, such as after a hangfire. Also safes the Arming, which can't be re-armed until the
+// lockout ends, so the igniter isn't live again the moment it does.
+func (i *Igniter) Lockout(d time.Duration) {
+	i.FirePin.Out(gpio.Low)
+	i.Lock()
+	i.lockoutUntil = i.now().Add(d)
+	i.Unlock()
+	if i.arming != nil {
+		i.arming.Safe()
+	}
+	i.Emit(i.GetState())
+
+	// Let everyone know when it's safe again.
+	time.AfterFunc(d, func() {
+		i.Emit(i.GetState())
+	})
+}
+
+func (i *Igniter) LockedOut() bool {
+	return i.LockoutRemaining() > 0
+}
+
+// Time remaining on a lockout.
+func (i *Igniter) LockoutRemaining() time.Duration {
+	i.Lock()
+	defer i.Unlock()
+
+	if remaining := i.lockoutUntil.Sub(i.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
 }
 
 func (i *Igniter) IsReady() (bool) {
@@ -139,28 +247,62 @@ func (i *Igniter) IsFiring() bool {
 	return i.FirePin.Read() == gpio.High
 }
 
+// Sets the Arming which must be armed before the igniter will fire. It refuses to arm while the igniter is locked out.
+func (i *Igniter) SetArming(a *Arming) {
+	i.arming = a
+	if a != nil {
+		a.Lock()
+		a.lockout = i.LockoutRemaining
+		a.Unlock()
+	}
+}
+
+func (i *Igniter) Arming() *Arming {
+	return i.arming
+}
+
+// True if the key and software are both armed. Without Arming, the igniter is never armed.
+func (i *Igniter) IsArmed() bool {
+	return i.arming != nil && i.arming.IsArmed()
+}
+
 func (i *Igniter) Fire() (error) {
-	i.firing = true
+	return i.FireContext(context.Background())
+}
+
+// Fires the igniter, stopping (with the FirePin low) as soon as ctx is done.
+func (i *Igniter) FireContext(ctx context.Context) (error) {
+	if i.LockedOut() {
+		return errors.New("igniter locked out")
+	}
+	if !i.IsArmed() {
+		return errors.New("igniter not armed")
+	}
+
+	i.setFiring(true)
 	var pulse time.Duration = 0
 
 	// Pulse up to 1 second.
-	for i.IsReady() && pulse.Seconds() < 1 {
+	for ctx.Err() == nil && i.IsReady() && i.IsArmed() && pulse.Seconds() < 1 {
 		pulse += 250 * time.Millisecond
 
 		i.FirePin.Out(gpio.Low)
 		i.FirePin.Out(gpio.High)
 		i.Emit(i.GetState())
-		time.Sleep(pulse)
+		sleepContext(ctx, pulse)
 
 		i.FirePin.Out(gpio.Low)
 		i.Emit(i.GetState())
-		time.Sleep(500 * time.Millisecond) // half-second between pulses.
+		sleepContext(ctx, 500 * time.Millisecond) // half-second between pulses.
 	}
-	i.firing = false;
+	i.setFiring(false)
 	i.Emit(i.GetState())
 
 	// Never fired, not forced.
 	if pulse == 0 {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return errors.New("igniter not ready")
 	}
 
@@ -168,16 +310,40 @@ func (i *Igniter) Fire() (error) {
 	return nil
 }
 
-func (i *Igniter) Emit(v interface{}) {
-	if i.Recording {
-		go func(igniter *Igniter, state IgniterState) {
-			igniter.Lock()
-			defer igniter.Unlock()
+func (i *Igniter) setFiring(firing bool) {
+	i.Lock()
+	defer i.Unlock()
 
-			if state.Recording {
-				igniter.recordedState = append(igniter.recordedState, state)
-			}
-		}(i, v.(IgniterState))
+	i.firing = firing
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
 	}
+}
+
+func (i *Igniter) Emit(v interface{}) {
+	go func(igniter *Igniter, state IgniterState) {
+		igniter.Lock()
+		defer igniter.Unlock()
+
+		if state.Recording {
+			igniter.recordedState = append(igniter.recordedState, state)
+		} else if igniter.preTrigger > 0 && !igniter.Recording {
+			igniter.history = append(igniter.history, state)
+			// Forget states which have fallen out of the window. States are stamped in seconds.
+			since := time.Now().Add(-igniter.preTrigger).Unix()
+			drop := 0
+			for drop < len(igniter.history) && igniter.history[drop].Timestamp < since {
+				drop++
+			}
+			igniter.history = igniter.history[drop:]
+		}
+	}(i, v.(IgniterState))
 	i.Emitter.Emit(v)
 }
\ No newline at end of file
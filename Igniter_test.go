@@ -0,0 +1,119 @@
+package pi_launch_control
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIgniterPreTriggerSeedsRecording(t *testing.T) {
+	sim := NewSimulatedIgniter(DefaultSimulatedIgniterConfig())
+	igniter, err := sim.NewIgniter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	igniter.SetPreTrigger(2 * time.Second)
+
+	// A state from before recording starts, ie: continuity lost and restored.
+	before := igniter.GetState()
+	before.Ready = false
+	igniter.Emit(before)
+	// Emit records in the background.
+	waitFor(t, func() bool {
+		igniter.Lock()
+		defer igniter.Unlock()
+		return len(igniter.history) > 0
+	})
+
+	igniter.StartRecording()
+	igniter.StopRecording()
+	waitFor(t, func() bool {
+		igniter.Lock()
+		defer igniter.Unlock()
+		return len(igniter.recordedState) >= 2
+	})
+
+	var recorded []IgniterState
+	for header, data := range igniter.GetRecordedData() {
+		if header.Name != "igniter.json" {
+			t.Fatalf("unexpected file %s", header.Name)
+		}
+		if err := json.Unmarshal(data, &recorded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Other pre-trigger states, ie: from the continuity edge, may be in there too.
+	seeded := false
+	for _, state := range recorded {
+		seeded = seeded || (!state.Recording && !state.Ready)
+	}
+	if !seeded {
+		t.Fatalf("igniter.json doesn't have the pre-trigger state: %+v", recorded)
+	}
+}
+
+func TestIgniterWithoutPreTrigger(t *testing.T) {
+	sim := NewSimulatedIgniter(DefaultSimulatedIgniterConfig())
+	igniter, err := sim.NewIgniter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	igniter.Emit(igniter.GetState())
+	igniter.StartRecording()
+	waitFor(t, func() bool {
+		igniter.Lock()
+		defer igniter.Unlock()
+		return len(igniter.recordedState) >= 1
+	})
+
+	igniter.Lock()
+	defer igniter.Unlock()
+	for _, state := range igniter.recordedState {
+		if !state.Recording {
+			t.Fatalf("recorded a state from before recording: %+v", state)
+		}
+	}
+}
+
+// Polls cond until it's true, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestArmingRefusedDuringLockout(t *testing.T) {
+	clock := NewManualTimeSource(time.Now())
+	sim := NewSimulatedIgniter(DefaultSimulatedIgniterConfig())
+	igniter, err := sim.NewIgniter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	igniter.SetTimeSource(clock)
+	arming, err := sim.NewArming()
+	if err != nil {
+		t.Fatal(err)
+	}
+	igniter.SetArming(arming)
+	sim.SetKey(true)
+	waitFor(t, arming.KeyArmed)
+
+	igniter.Lockout(time.Minute)
+	if err := arming.Arm(); err == nil {
+		t.Fatal("armed during a lockout")
+	}
+
+	clock.Advance(time.Minute)
+	if err := arming.Arm(); err != nil {
+		t.Fatal(err)
+	}
+	if !igniter.IsArmed() {
+		t.Fatal("not armed after the lockout")
+	}
+}
@@ -1,117 +1,843 @@
 package pi_launch_control
 
 import (
+	"archive/zip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/bvarner/pi-launch-control/analysis"
+	"math"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// How a Mission starts recording.
+type MissionMode string
+
+const (
+	// Counts down, fires the igniter at T-0 and records from PreRecord before it.
+	MissionCountdown	MissionMode = "countdown"
+	// Never fires. Waits for thrust over ThrustThreshold, ie: from a hand-lit motor, then records until it
+	// stays under ReleaseThreshold for ReleaseTime.
+	MissionTriggered	MissionMode = "triggered"
+)
+
+// Timeline of a Mission. All values are in seconds.
+//
+// swagger:model
+type MissionConfig struct {
+	// Countdown or triggered. Empty is taken as countdown.
+	Mode			MissionMode
+	// Length of the countdown to T-0.
+	Countdown		float64
+	// How long before T-0 to begin recording.
+	PreRecord		float64
+	// How much of what the devices captured before recording began to include in the recording.
+	PreTrigger		float64
+	// How long after T-0 to keep recording. Triggered missions stop recording this long after the trigger at the
+	// latest.
+	PostBurn		float64
+	// Resolution of the mission clock.
+	Tick			float64
+	// Clock (before T-0) to recycle to when resuming from a hold. 0 resumes where the hold began.
+	RecycleTo		float64
+	// Calibrated thrust, in newtons, which indicates the motor has lit. Newtons, so it means the same whatever unit
+	// the scale is calibrated in.
+	ThrustThreshold	float64
+	// Calibrated thrust, in newtons, which must stay under for ReleaseTime to end a triggered mission.
+	ReleaseThreshold	float64
+	ReleaseTime		float64
+	// How long after firing thrust must begin before declaring a hangfire.
+	IgnitionWindow	float64
+	// How long the igniter stays locked out after a hangfire.
+	Lockout			float64
+}
+
+func DefaultMissionConfig() MissionConfig {
+	return MissionConfig {
+		Countdown: 	10,
+		PreRecord: 	3,
+		PreTrigger: 2,
+		PostBurn: 	12,
+		Tick: 		1,
+		ThrustThreshold: 0.5,
+		ReleaseThreshold: 0.25,
+		ReleaseTime: 2,
+		IgnitionWindow: 3,
+		Lockout: 	60,
+	}
+}
+
+func (c MissionConfig) Validate() error {
+	if c.Mode != "" && c.Mode != MissionCountdown && c.Mode != MissionTriggered {
+		return fmt.Errorf("unknown Mode %q, use countdown or triggered", c.Mode)
+	}
+	if c.Tick <= 0 || c.Tick > 1 {
+		return errors.New("Tick must be greater than 0 and no more than 1 second")
+	}
+	if c.Countdown < c.Tick {
+		return errors.New("Countdown must be at least one Tick")
+	}
+	if c.PreRecord < 0 || c.PreRecord > c.Countdown {
+		return errors.New("PreRecord must be between 0 and Countdown")
+	}
+	if c.PreTrigger < 0 || missionDuration(c.PreTrigger) > MaxPreTrigger {
+		return fmt.Errorf("PreTrigger must be between 0 and %v seconds", MaxPreTrigger.Seconds())
+	}
+	if c.PostBurn <= 0 {
+		return errors.New("PostBurn must be greater than 0")
+	}
+	if c.RecycleTo > 0 || -c.RecycleTo > c.Countdown {
+		return errors.New("RecycleTo must be between -Countdown and 0")
+	}
+	if c.ThrustThreshold <= 0 {
+		return errors.New("ThrustThreshold must be greater than 0")
+	}
+	if c.IgnitionWindow <= 0 {
+		return errors.New("IgnitionWindow must be greater than 0")
+	}
+	if c.Lockout < 0 {
+		return errors.New("Lockout must not be negative")
+	}
+	if c.Triggered() {
+		if c.ReleaseThreshold <= 0 || c.ReleaseThreshold > c.ThrustThreshold {
+			return errors.New("ReleaseThreshold must be greater than 0 and no more than ThrustThreshold")
+		}
+		if c.ReleaseTime <= 0 {
+			return errors.New("ReleaseTime must be greater than 0")
+		}
+		// The trigger is only seen on a tick, so the recording must reach back at least that far.
+		if c.PreTrigger < c.Tick {
+			return errors.New("PreTrigger must be at least one Tick for a triggered mission")
+		}
+	}
+	return nil
+}
+
+// True if the mission waits for thrust rather than counting down.
+func (c MissionConfig) Triggered() bool {
+	return c.Mode == MissionTriggered
+}
+
+// Converts seconds on the mission timeline to a Duration.
+func missionDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// A hold during the countdown.
+//
+// swagger:model
+type MissionHold struct {
+	// Unix nanoseconds the hold began and ended. End is 0 while holding.
+	Start			int64
+	End				int64
+	// Clock when the hold began, and where it resumed.
+	Clock			float64
+	ResumedAt		float64
+}
+
+// Declared when the motor fails to produce thrust after the igniter fires.
+//
+// swagger:model
+type Hangfire struct {
+	Mission			int64
+	// Unix nanoseconds the igniter fired, and the hangfire was declared.
+	Fired			int64
+	Timestamp		int64
+	// Unix nanoseconds the lockout ends.
+	LockoutUntil	int64
+	// Largest calibrated thrust seen after firing, in newtons, if any.
+	PeakThrust		*float64
+}
+
+// Requests made of a running Mission, which are carried out by its run loop.
+type missionCommand int
+
+const (
+	missionAbort	missionCommand = iota
+	missionHold
+	missionResume
+)
+
+type missionRequest struct {
+	command			missionCommand
+	// Why, for missionAbort.
+	reason			AbortReason
+	detail			string
+	reply			chan error
+}
+
+// A single test of a motor, from countdown through to safing.
+//
+// Once started, all changes to the mission are made by one goroutine, which serializes ticks of the clock,
+// commands (abort, hold, resume) and the igniter firing. Exported fields must be read holding the lock.
 type Mission struct {
+	sync.Mutex		`json:"-"`
 	broker			*Broker
-	sequenceTicker 	*time.Ticker
+	timeSource		TimeSource
+	sequenceTicker 	Ticker
+	// Wall clock of T-0.
+	zero			time.Time
 
+	// Unique identifier, used when storing the mission.
+	ID				string
 	Timestamp		int64
-	Clock	 		int
+	Config			MissionConfig
+	Metadata		MissionMetadata
+	// Preflight checks run before starting.
+	Preflight		*PreflightReport
+	// Seconds relative to T-0.
+	Clock	 		float64
+	Phase			MissionPhase
+	Transitions		[]MissionTransition
+	Holds			[]MissionHold
+	// Total time spent holding, in seconds.
+	HoldDuration	float64
+	Hangfire		*Hangfire
+	// Thrust curve analysis, once a burn has been recorded.
+	Analysis		*analysis.Result
 	Aborted		   	bool
+	// Why the mission aborted, nil unless Aborted.
+	AbortCause		*MissionAbort
 	Complete 		bool
 
+	started			bool
+	commands		chan missionRequest
+	cancel			context.CancelFunc
+	done			chan struct{}
+
+	recording		bool
+	recorded		bool
+	// Devices recording, which the countdown can't continue without.
+	recordingScale	bool
+	recordingCamera	bool
+	fired			time.Time
+	// Timestamp of the newest scale sample when the igniter fired. Samples are stamped on the scale's clock, so
+	// thrust is looked for after this rather than after fired.
+	firedSample		int64
+	// For triggered missions, when thrust crossed ThrustThreshold, and was last over ReleaseThreshold.
+	triggered		time.Time
+	released		time.Time
+	// Timestamp of the newest scale sample already looked at for thrust.
+	watched			int64
+	// Set while the igniter is firing. Cancelling fireCancel stops it, and the result arrives on fireResult.
+	firing			bool
+	fireCancel		context.CancelFunc
+	fireResult		chan error
+
+	store			*MissionStore
+	saved			bool
+
 	igniter         *Igniter
 	scale 			*Scale
 	camera 			*Camera
 }
 
-func NewMission(igniter *Igniter, scale *Scale, camera *Camera) *Mission {
+func NewMission(igniter *Igniter, scale *Scale, camera *Camera, config MissionConfig, metadata MissionMetadata) *Mission {
+	now := time.Now().UnixNano()
 	m := &Mission {
 		broker: nil,
+		timeSource: WallTime,
 		sequenceTicker: nil,
 
-		Timestamp: time.Now().UnixNano(),
-		Clock: -10,
+		ID: strconv.FormatInt(now, 10),
+		Timestamp: now,
+		Config: config,
+		Metadata: metadata,
+		Clock: -config.Countdown,
+		Phase: PhaseIdle,
+		Transitions: make([]MissionTransition, 0),
+		Holds: make([]MissionHold, 0),
 		Aborted: false,
 		Complete: false,
 
+		commands: make(chan missionRequest),
+		done: make(chan struct{}),
+		fireResult: make(chan error, 1),
+
 		igniter: igniter,
 		scale: scale,
 		camera: camera,
 	}
+	// A triggered mission's clock reads 0 until the trigger, and is relative to it after.
+	if config.Triggered() {
+		m.Clock = 0
+	}
 	return m
 }
 
-func (m *Mission) mission() {
-	m.Clock = -10 // 10 Second Countdown
-	for range m.sequenceTicker.C {
-		if !m.Aborted {
-			// At t - 3, start recording.
-			if m.Clock == -3 {
-				// Igniter First.
-				m.igniter.StartRecording()
-				// Scale Second.
-				if m.scale.Initialized {
-					m.scale.StartRecording()
-				}
-				// Camera Last.
-				if m.camera.Initialized {
-					m.camera.StartRecording()
-				}
-			}
+// Sets where the mission gets the time from. Must be called before Start. Give the igniter the same source, so
+// hangfire lockouts run on the mission's clock.
+func (m *Mission) SetTimeSource(ts TimeSource) {
+	m.timeSource = ts
+}
 
-			// anytime before ignition the igniter fails,
-			if m.Clock <= 0 && !m.igniter.IsReady() {
-				m.Aborted = true
-			}
+func (m *Mission) now() time.Time {
+	return m.timeSource.Now()
+}
 
-			// At Zero, Fire if not aborted.
-			if m.Clock == 0 && !m.Aborted {
-				m.igniter.Fire()
-			}
+// Moves the mission to the next phase, emitting a MissionPhase event.
+func (m *Mission) transition(to MissionPhase) error {
+	if err := m.Phase.CanTransition(to); err != nil {
+		return err
+	}
+
+	t := newMissionTransition(m, to)
+	m.Transitions = append(m.Transitions, t)
+	m.Phase = to
+	m.Aborted = to == PhaseAborted
+	m.Complete = to == PhaseComplete
+
+	m.send("MissionPhase", t)
+	return nil
+}
 
-			// When the clock is +12, Mission Complete.
-			if m.Clock >= 12 {
-				m.Complete = true
-				m.stop()
+func (m *Mission) send(event string, v interface{}) {
+	if m.broker == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		s := fmt.Sprintf("event: %s\ndata: %s\n", event, string(b))
+		m.broker.Outgoing <- s
+	}
+}
+
+// Begins the countdown. Cancelling ctx aborts the mission.
+func (m *Mission) Start(ctx context.Context, broker *Broker) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.started {
+		return errors.New("mission already started")
+	}
+	// A triggered mission has nothing else to go on.
+	if m.Config.Triggered() && (m.scale == nil || !m.scale.IsInitialized() || !m.scale.IsCalibrated()) {
+		return errors.New("a triggered mission needs a calibrated scale")
+	}
+	// Only a triggered mission, which never fires, can do without the igniter.
+	if !m.Config.Triggered() && m.igniter == nil {
+		return errors.New("a countdown mission needs an igniter")
+	}
+	// Set first, so the Armed event goes out.
+	m.broker = broker
+	if err := m.transition(PhaseArmed); err != nil {
+		return err
+	}
+
+	m.started = true
+
+	// Start keeping history now, so the pre-trigger window is full when recording begins.
+	preTrigger := missionDuration(m.Config.PreTrigger)
+	if m.igniter != nil {
+		m.igniter.SetPreTrigger(preTrigger)
+	}
+	if m.scale != nil {
+		m.scale.SetPreTrigger(preTrigger)
+	}
+	if m.camera != nil {
+		m.camera.SetPreTrigger(preTrigger)
+	}
+	m.sequenceTicker = m.timeSource.NewTicker(missionDuration(m.Config.Tick))
+	if m.Config.Triggered() {
+		m.watched = m.scale.LastSample()
+	} else {
+		// The first tick reads -Countdown.
+		m.zero = m.now().Add(missionDuration(m.Config.Countdown + m.Config.Tick))
+		m.transition(PhaseCountdown)
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	go m.run(ctx)
+	return nil
+}
+
+// The mission's run loop. Everything which changes the mission once started happens here.
+func (m *Mission) run(ctx context.Context) {
+	defer close(m.done)
+	defer m.cancel()
+
+	for {
+		var req *missionRequest
+		var err error
+
+		select {
+		case <-ctx.Done():
+			m.Lock()
+			m.abort(AbortCancelled, ctx.Err().Error())
+		case r := <-m.commands:
+			req = &r
+			m.Lock()
+			err = m.command(r)
+		case <-m.sequenceTicker.C():
+			m.Lock()
+			if m.Config.Triggered() {
+				m.watch()
+			} else {
+				m.tick()
 			}
+		case ferr := <-m.fireResult:
+			m.Lock()
+			m.fireFinished(ferr)
+		}
+
+		over := m.finish()
+		m.Unlock()
+
+		// Only reply once the mission has caught up, so an abort has safed everything by the time it returns.
+		if req != nil {
+			req.reply <- err
+		}
+		if over {
+			return
+		}
+	}
+}
+
+// Carries out a command from outside the run loop.
+func (m *Mission) command(r missionRequest) error {
+	switch r.command {
+	case missionAbort:
+		m.abort(r.reason, r.detail)
+		return nil
+	case missionHold:
+		return m.hold()
+	case missionResume:
+		return m.resume()
+	}
+	return fmt.Errorf("unknown mission command %d", r.command)
+}
+
+// Sends a command to the run loop, and waits for it to be carried out.
+func (m *Mission) request(r missionRequest) error {
+	m.Lock()
+	started := m.started
+	m.Unlock()
+	if !started {
+		return errors.New("mission has not started")
+	}
+
+	r.reply = make(chan error, 1)
+	select {
+	case m.commands <- r:
+		return <-r.reply
+	case <-m.done:
+		return errors.New("mission is over")
+	}
+}
+
+// Runs a single tick of the mission clock.
+func (m *Mission) tick() {
+	// The clock is taken from the wall, so a slow tick doesn't stretch the timeline.
+	// While holding, the clock stands still.
+	if m.Phase != PhaseHold {
+		tick := missionDuration(m.Config.Tick)
+		ticks := int64(math.Round(float64(m.now().Sub(m.zero)) / float64(tick)))
+		m.Clock = (time.Duration(ticks) * tick).Seconds()
+	}
+	t := missionDuration(m.Clock)
+
+	if m.Phase.Final() {
+		return
+	}
+
+	// Start recording ahead of T-0, once.
+	if !m.recorded && t >= -missionDuration(m.Config.PreRecord) {
+		m.startRecording()
+	}
+
+	// anytime before ignition the igniter fails,
+	if m.Phase == PhaseCountdown && !m.igniter.IsReady() {
+		m.abort(AbortContinuity, "igniter continuity lost")
+		return
+	}
+
+	// Disarming before the motor lights always aborts.
+	if (m.Phase == PhaseCountdown || m.Phase == PhaseHold || m.Phase == PhaseIgnition) && !m.igniter.IsArmed() {
+		m.abort(AbortDisarmed, "disarmed before ignition")
+		return
+	}
+
+	// Don't light a motor we can't record.
+	if m.Phase == PhaseCountdown || m.Phase == PhaseHold {
+		if m.recordingScale && !m.scale.IsInitialized() {
+			m.abort(AbortDevice, "scale stopped during countdown")
+			return
+		}
+		if m.recordingCamera && !m.camera.IsInitialized() {
+			m.abort(AbortDevice, "camera stopped during countdown")
+			return
+		}
+	}
+
+	// At Zero, Fire if not aborted.
+	if t >= 0 && m.Phase == PhaseCountdown {
+		m.transition(PhaseIgnition)
+		m.fire()
+	}
+
+	// Make sure the motor actually lit.
+	if m.Phase == PhaseIgnition {
+		m.checkIgnition()
+	}
+
+	// Hold everyone back until the lockout expires. Record for as long as a burn would have, not for the whole
+	// lockout, which would buffer minutes of camera frames.
+	if m.Phase == PhaseSafing && m.Hangfire != nil {
+		if m.recording && m.now().Sub(m.fired) >= missionDuration(m.Config.IgnitionWindow + m.Config.PostBurn) {
+			m.stop()
 		}
+		if !m.igniter.LockedOut() {
+			m.abort(AbortHangfire, "motor did not ignite")
+		}
+	}
+
+	// Once we've recorded long enough after ignition, safe everything and complete.
+	if t >= missionDuration(m.Config.PostBurn) && m.Phase == PhaseBurn {
+		m.transition(PhaseSafing)
+		m.stop()
+		m.transition(PhaseComplete)
+	}
+}
+
+// Runs a single tick of a triggered mission, starting and stopping the recording as the thrust comes and goes.
+func (m *Mission) watch() {
+	if m.Phase.Final() {
+		return
+	}
+	now := m.now()
+	if !m.triggered.IsZero() {
+		m.Clock = now.Sub(m.zero).Seconds()
+	}
+
+	if !m.scale.IsInitialized() {
+		m.abort(AbortDevice, "scale stopped")
+		return
+	}
+	if m.recordingCamera && !m.camera.IsInitialized() {
+		m.abort(AbortDevice, "camera stopped")
+		return
+	}
 
-		if m.Aborted {
+	// Only look at what's arrived since the last tick.
+	peak, latest, ok := m.scale.PeakThrust(m.watched)
+	m.watched = latest
+
+	switch m.Phase {
+	case PhaseArmed:
+		if ok && peak >= m.Config.ThrustThreshold {
+			m.triggered, m.released = now, now
+			m.zero = now
+			m.startRecording()
+			m.transition(PhaseBurn)
+		}
+	case PhaseBurn:
+		if ok && peak >= m.Config.ReleaseThreshold {
+			m.released = now
+		}
+		if now.Sub(m.released) >= missionDuration(m.Config.ReleaseTime) || now.Sub(m.triggered) >= missionDuration(m.Config.PostBurn) {
+			m.transition(PhaseSafing)
 			m.stop()
+			m.transition(PhaseComplete)
+		}
+	}
+}
+
+// Starts every device recording, each from its pre-trigger history.
+func (m *Mission) startRecording() {
+	m.recording = true
+	m.recorded = true
+	// Igniter First.
+	if m.igniter != nil {
+		m.igniter.StartRecording()
+	}
+	// Scale Second.
+	if m.scale != nil && m.scale.IsInitialized() {
+		m.recordingScale = true
+		m.scale.StartRecording()
+	}
+	// Camera Last.
+	if m.camera != nil && m.camera.IsInitialized() {
+		m.recordingCamera = true
+		m.camera.StartRecording()
+	}
+}
+
+// Fires the igniter in the background, so the run loop can still abort.
+func (m *Mission) fire() {
+	var ctx context.Context
+	ctx, m.fireCancel = context.WithCancel(context.Background())
+	m.firing = true
+	m.fired = m.now()
+	if m.scale != nil {
+		m.firedSample = m.scale.LastSample()
+	}
+
+	go func(igniter *Igniter, result chan<- error) {
+		result <- igniter.FireContext(ctx)
+	}(m.igniter, m.fireResult)
+}
+
+func (m *Mission) fireFinished(err error) {
+	m.firing = false
+	m.fireCancel()
+
+	if err != nil && m.Phase == PhaseIgnition {
+		m.abort(AbortDevice, "igniter did not fire: " + err.Error())
+	}
+}
+
+// Stops the igniter firing, and waits for the FirePin to drop.
+func (m *Mission) stopFiring() {
+	if !m.firing {
+		return
+	}
+	m.fireCancel()
+	<-m.fireResult
+	m.firing = false
+}
+
+// Moves from Ignition to Burn once thrust is seen, or declares a hangfire if it isn't seen in time.
+func (m *Mission) checkIgnition() {
+	// Without a calibrated scale we have no way of knowing. Carry on as if it lit, once the igniter has fired.
+	if m.scale == nil || !m.scale.IsInitialized() || !m.scale.IsCalibrated() {
+		if !m.firing {
+			m.transition(PhaseBurn)
 		}
+		return
+	}
+
+	peak, _, ok := m.scale.PeakThrust(m.firedSample)
+	if ok && peak >= m.Config.ThrustThreshold {
+		m.transition(PhaseBurn)
+		return
+	}
+
+	if m.now().Sub(m.fired) < missionDuration(m.Config.IgnitionWindow) {
+		return
+	}
+
+	// Hangfire. Keep the fire circuit dead until it's safe to approach.
+	m.stopFiring()
+	lockout := missionDuration(m.Config.Lockout)
+	m.igniter.Lockout(lockout)
+
+	now := m.now()
+	m.Hangfire = &Hangfire {
+		Mission: 		m.Timestamp,
+		Fired: 			m.fired.UnixNano(),
+		Timestamp: 		now.UnixNano(),
+		LockoutUntil: 	now.Add(lockout).UnixNano(),
+	}
+	if ok {
+		m.Hangfire.PeakThrust = &peak
+	}
+	m.transition(PhaseSafing)
+	m.send("Hangfire", m.Hangfire)
+}
+
+// Safes everything and aborts. Does nothing if the mission is already over.
+func (m *Mission) abort(reason AbortReason, detail string) {
+	if m.Phase.Final() {
+		return
+	}
+	m.stop()
+
+	m.AbortCause = &MissionAbort {
+		Reason: 	reason,
+		Detail: 	detail,
+		Phase: 		m.Phase,
+		Clock: 		m.Clock,
+		Timestamp: 	m.now().UnixNano(),
+	}
+	m.transition(PhaseAborted)
+}
+
+// Once the mission is over, safes and saves it. Returns true when the run loop should exit.
+func (m *Mission) finish() bool {
+	if !m.Phase.Final() {
+		m.send("Mission", m)
+		return false
+	}
+
+	m.stop()
 
-		b, err := json.Marshal(m)
-		if err == nil {
-			s := fmt.Sprintf("event: %s\ndata: %s\n", "Mission", string(b))
-			m.broker.Outgoing <- s
+	// Once the igniter has fired, or thrust triggered the recording, see what the motor did.
+	if m.recordingScale && (!m.fired.IsZero() || !m.triggered.IsZero()) && m.Analysis == nil {
+		if result, err := AnalyzeSamples(m.scale.RecordedSamples(), true); err == nil {
+			m.Analysis = &result
+			m.send("MissionAnalysis", m.Analysis)
+		} else {
+			fmt.Println("Thrust analysis:", err)
 		}
-		m.Clock++
+	}
 
-		// Clean up
-		if m.sequenceTicker == nil {
-			m.broker = nil
-			break
+	// Keep the data safe from the next mission.
+	if m.store != nil && !m.saved {
+		m.saved = true
+		if _, err := m.store.Save(m, m.recordedData()); err != nil {
+			fmt.Println("Error saving mission:", err)
 		}
 	}
+
+	m.send("Mission", m)
+
+	// Clean up
+	if m.sequenceTicker != nil {
+		m.sequenceTicker.Stop()
+	}
+	m.broker = nil
+	return true
 }
 
+// Sets where the mission is saved once it completes or aborts.
+func (m *Mission) SetStore(store *MissionStore) {
+	m.store = store
+}
 
-func (m *Mission) Start(broker *Broker) {
-	m.broker = broker
-	m.sequenceTicker = time.NewTicker(1 * time.Second)
-	go m.mission()
+// Replaces the mission metadata. Once the mission has been saved, the stored copy is updated as well.
+func (m *Mission) SetMetadata(metadata MissionMetadata) error {
+	if err := metadata.Validate(); err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.Metadata = metadata
+	m.send("Mission", m)
+
+	if m.saved {
+		return m.store.SetMetadata(m.ID, metadata)
+	}
+	return nil
 }
 
+// Collects the recorded files from each device.
+func (m *Mission) recordedData() []map[*zip.FileHeader][]byte {
+	devices := make([]map[*zip.FileHeader][]byte, 0)
+
+	// Whatever the devices have is from an earlier mission.
+	if !m.recorded {
+		return devices
+	}
+
+	if m.igniter != nil {
+		devices = append(devices, m.igniter.GetRecordedData())
+	}
+	if m.scale != nil && m.scale.IsInitialized() {
+		devices = append(devices, m.scale.GetRecordedData())
+	}
+	if m.camera != nil && m.camera.IsInitialized() {
+		devices = append(devices, m.camera.GetRecordedData())
+	}
+	return devices
+}
+
+// Stops firing and recording. Safe to call more than once.
 func (m *Mission) stop() {
-	m.sequenceTicker.Stop()
-	m.sequenceTicker = nil
+	m.stopFiring()
+
+	if !m.recording {
+		return
+	}
+	m.recording = false
 
 	// Igniter Last. (inverse order)
-	if m.camera.Initialized {
+	if m.camera != nil && m.camera.IsInitialized() {
 		m.camera.StopRecording()
 	}
-	if m.scale.Initialized {
+	if m.scale != nil && m.scale.IsInitialized() {
 		m.scale.StopRecording()
 	}
-	m.igniter.StopRecording()
+	if m.igniter != nil {
+		m.igniter.StopRecording()
+	}
 }
 
-func (m *Mission) Abort() {
-	m.Aborted = true;
+// Aborts the mission on behalf of an operator, returning once the igniter and recording have been safed.
+func (m *Mission) Abort() error {
+	return m.AbortFor(AbortOperator, "aborted by operator")
+}
+
+// Aborts the mission for the given reason, returning once the igniter and recording have been safed.
+func (m *Mission) AbortFor(reason AbortReason, detail string) error {
+	m.Lock()
+	if !m.started {
+		// Never started, there's nothing to safe. Still keep a record of it.
+		defer m.Unlock()
+		if m.Phase.Final() {
+			return nil
+		}
+		m.abort(reason, detail)
+		m.finish()
+		close(m.done)
+		return nil
+	}
+	m.Unlock()
+
+	if err := m.request(missionRequest{ command: missionAbort, reason: reason, detail: detail }); err != nil && !m.Finished() {
+		return err
+	}
+	return nil
+}
+
+// Closed once the mission is over, safed and saved.
+func (m *Mission) Done() <-chan struct{} {
+	return m.done
+}
+
+// True once the mission is over.
+func (m *Mission) Finished() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.Phase.Final()
+}
+
+// Stops the countdown clock, keeping the mission armed and recording.
+func (m *Mission) Hold() error {
+	return m.request(missionRequest{ command: missionHold })
+}
+
+func (m *Mission) hold() error {
+	if err := m.transition(PhaseHold); err != nil {
+		return err
+	}
+	m.Holds = append(m.Holds, MissionHold {
+		Start: m.now().UnixNano(),
+		Clock: m.Clock,
+	})
+	return nil
+}
+
+// Restarts the countdown clock after a hold, recycling it if configured.
+func (m *Mission) Resume() error {
+	return m.request(missionRequest{ command: missionResume })
+}
+
+func (m *Mission) resume() error {
+	if m.Phase != PhaseHold {
+		return errors.New("mission is not holding")
+	}
+	if !m.igniter.IsReady() {
+		return errors.New("igniter not ready")
+	}
+
+	hold := &m.Holds[len(m.Holds) - 1]
+	now := m.now()
+	hold.End = now.UnixNano()
+	hold.ResumedAt = hold.Clock
+	if m.Config.RecycleTo != 0 && m.Config.RecycleTo < hold.Clock {
+		hold.ResumedAt = m.Config.RecycleTo
+	}
+	m.HoldDuration += time.Duration(hold.End - hold.Start).Seconds()
+
+	// Move T-0 so the clock picks up where we're resuming.
+	m.zero = now.Add(-missionDuration(hold.ResumedAt))
+	m.Clock = hold.ResumedAt
+
+	return m.transition(PhaseCountdown)
 }
@@ -0,0 +1,34 @@
+package pi_launch_control
+
+// Why a Mission aborted.
+type AbortReason string
+
+const (
+	// An operator aborted the mission.
+	AbortOperator		AbortReason = "Operator"
+	// Igniter continuity was lost before ignition.
+	AbortContinuity		AbortReason = "Continuity"
+	// The arming key or software arm was turned off before the motor lit.
+	AbortDisarmed		AbortReason = "Disarmed"
+	// Preflight checks failed, and weren't overridden.
+	AbortPreflight		AbortReason = "Preflight"
+	// A device failed, or the igniter refused to fire.
+	AbortDevice			AbortReason = "Device"
+	// The motor didn't light, and the lockout has expired.
+	AbortHangfire		AbortReason = "Hangfire"
+	// The mission's context was cancelled, ie: the server is shutting down.
+	AbortCancelled		AbortReason = "Cancelled"
+)
+
+// Describes why, and when, a Mission aborted.
+//
+// swagger:model
+type MissionAbort struct {
+	Reason			AbortReason
+	Detail			string
+	// Phase, and mission clock, when the abort happened.
+	Phase			MissionPhase
+	Clock			float64
+	// Unix nanoseconds of the abort.
+	Timestamp		int64
+}
@@ -0,0 +1,62 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Describes what was tested, and by whom.
+//
+// swagger:model
+type MissionMetadata struct {
+	// Motor designation, ie: "F15-0".
+	Motor			string
+	Manufacturer	string
+	// Propellant mass and total motor mass, in grams.
+	PropellantMass	float64
+	MotorMass		float64
+	// Motor case, for reloadable motors.
+	Case			string
+	Operator		string
+	Location		string
+	Notes			string
+	Tags			[]string
+}
+
+func (md MissionMetadata) Validate() error {
+	if md.PropellantMass < 0 {
+		return errors.New("PropellantMass must not be negative")
+	}
+	if md.MotorMass < 0 {
+		return errors.New("MotorMass must not be negative")
+	}
+	if md.MotorMass > 0 && md.PropellantMass > md.MotorMass {
+		return errors.New("PropellantMass must not exceed MotorMass")
+	}
+	for _, tag := range md.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("Tags must not be blank")
+		}
+	}
+	return nil
+}
+
+// Marshals the metadata as stored in metadata.json.
+func (md MissionMetadata) marshal() ([]byte, error) {
+	if md.Tags == nil {
+		md.Tags = make([]string, 0)
+	}
+	return json.Marshal(md)
+}
+
+// The metadata as a metadata.json archive entry.
+func (md MissionMetadata) ArchiveFile() (map[*zip.FileHeader][]byte, error) {
+	b, err := md.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveFile("metadata.json", b), nil
+}
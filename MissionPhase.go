@@ -0,0 +1,77 @@
+package pi_launch_control
+
+import (
+	"fmt"
+)
+
+// Phase of a Mission.
+type MissionPhase string
+
+const (
+	PhaseIdle		MissionPhase = "Idle"
+	PhaseArmed		MissionPhase = "Armed"
+	PhaseCountdown	MissionPhase = "Countdown"
+	PhaseHold		MissionPhase = "Hold"
+	PhaseIgnition	MissionPhase = "Ignition"
+	PhaseBurn		MissionPhase = "Burn"
+	PhaseSafing		MissionPhase = "Safing"
+	PhaseComplete	MissionPhase = "Complete"
+	PhaseAborted	MissionPhase = "Aborted"
+)
+
+// Phases each phase may move to.
+var missionTransitions = map[MissionPhase][]MissionPhase {
+	PhaseIdle: 		{ PhaseArmed, PhaseAborted },
+	// Triggered missions go straight to Burn once thrust is seen.
+	PhaseArmed: 	{ PhaseCountdown, PhaseBurn, PhaseAborted },
+	PhaseCountdown: { PhaseHold, PhaseIgnition, PhaseAborted },
+	PhaseHold: 		{ PhaseCountdown, PhaseAborted },
+	PhaseIgnition: 	{ PhaseBurn, PhaseSafing, PhaseAborted },
+	PhaseBurn: 		{ PhaseSafing, PhaseAborted },
+	PhaseSafing: 	{ PhaseComplete, PhaseAborted },
+	PhaseComplete: 	{},
+	PhaseAborted: 	{},
+}
+
+// True if a Mission in this phase is over.
+func (p MissionPhase) Final() bool {
+	return p == PhaseComplete || p == PhaseAborted
+}
+
+// Returns nil if a Mission may move from p to next.
+func (p MissionPhase) CanTransition(next MissionPhase) error {
+	allowed, ok := missionTransitions[p]
+	if !ok {
+		return fmt.Errorf("unknown mission phase %s", p)
+	}
+	for _, a := range allowed {
+		if a == next {
+			return nil
+		}
+	}
+	return fmt.Errorf("mission cannot move from %s to %s", p, next)
+}
+
+// A change of Mission phase.
+//
+// swagger:model
+type MissionTransition struct {
+	// Mission Timestamp this belongs to.
+	Mission			int64
+	From			MissionPhase
+	To				MissionPhase
+	// Unix nanoseconds of the transition.
+	Timestamp		int64
+	// Mission clock at the transition.
+	Clock			float64
+}
+
+func newMissionTransition(m *Mission, to MissionPhase) MissionTransition {
+	return MissionTransition {
+		Mission: 	m.Timestamp,
+		From: 		m.Phase,
+		To: 		to,
+		Timestamp: 	m.now().UnixNano(),
+		Clock: 		m.Clock,
+	}
+}
@@ -0,0 +1,685 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bvarner/pi-launch-control/analysis"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Summary of a stored mission.
+//
+// swagger:model
+type MissionSummary struct {
+	ID				string
+	Timestamp		int64
+	Phase			MissionPhase
+	Aborted			bool
+	Complete		bool
+	Hangfire		bool
+	AbortReason		AbortReason		`json:",omitempty"`
+	Metadata		MissionMetadata
+	// Number of files, and size in bytes, of the archive.
+	Files			int
+	Size			int64
+	// Unix nanoseconds the mission was saved.
+	Saved			int64
+}
+
+// Persists finished missions, and their recorded data, to a directory.
+//
+// Each mission is stored in its own directory, named by ID, holding mission.json, metadata.json, summary.json
+// and mission.zip.
+type MissionStore struct {
+	Dir				string
+
+	// Called as each file is written to a mission archive.
+	Progress		func(id string, total int, complete int, err error)
+	// Control actions taken during each mission are archived from here, if set.
+	Audit			*AuditLog
+
+	sync.Mutex
+	pending			map[string]chan bool
+	latest			string
+}
+
+var ErrMissionNotFound = errors.New("mission not found")
+
+func NewMissionStore(dir string) (*MissionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &MissionStore {
+		Dir: 		dir,
+		pending: 	make(map[string]chan bool),
+	}, nil
+}
+
+// Writes a mission archive containing mission.json and every device's recorded files.
+func WriteMissionArchive(w io.Writer, mission []byte, devices []map[*zip.FileHeader][]byte, progress func(total int, complete int)) (int, error) {
+	zw := zip.NewWriter(w)
+
+	all := make([]map[*zip.FileHeader][]byte, 0, len(devices) + 1)
+	if mission != nil {
+		all = append(all, archiveFile("mission.json", mission))
+	}
+	all = append(all, devices...)
+
+	total := 0
+	for _, data := range all {
+		total += len(data)
+	}
+
+	complete := 0
+	for _, data := range all {
+		for fname, fdata := range data {
+			f, err := zw.CreateHeader(fname)
+			if err != nil {
+				return complete, err
+			}
+			if _, err = f.Write(fdata); err != nil {
+				return complete, err
+			}
+			complete++
+			if progress != nil {
+				progress(total, complete)
+			}
+		}
+	}
+
+	return complete, zw.Close()
+}
+
+// Stores the mission and its recorded device data.
+//
+// Mission state is captured before returning. The archive is written in the background, and the ID reserved
+// so that Archive() will wait for it.
+func (s *MissionStore) Save(m *Mission, devices []map[*zip.FileHeader][]byte) (string, error) {
+	id := m.ID
+	mission, err := json.Marshal(m)
+	if err != nil {
+		return id, err
+	}
+	metadata, err := m.Metadata.marshal()
+	if err != nil {
+		return id, err
+	}
+	file, err := m.Metadata.ArchiveFile()
+	if err != nil {
+		return id, err
+	}
+	devices = append([]map[*zip.FileHeader][]byte{ file }, devices...)
+
+	var analyzed []byte
+	if m.Analysis != nil {
+		if analyzed, err = json.Marshal(m.Analysis); err != nil {
+			return id, err
+		}
+		file, err := AnalysisArchiveFile(m.Analysis)
+		if err != nil {
+			return id, err
+		}
+		devices = append(devices, file)
+	}
+
+	var reason AbortReason
+	if m.AbortCause != nil {
+		reason = m.AbortCause.Reason
+	}
+	summary := MissionSummary {
+		ID: 		id,
+		Timestamp: 	m.Timestamp,
+		Phase: 		m.Phase,
+		Aborted: 	m.Aborted,
+		Complete: 	m.Complete,
+		Hangfire: 	m.Hangfire != nil,
+		AbortReason: reason,
+		Metadata: 	m.Metadata,
+	}
+
+	s.Lock()
+	done := make(chan bool)
+	s.pending[id] = done
+	s.latest = id
+	s.Unlock()
+
+	go func() {
+		err := s.write(id, mission, metadata, analyzed, summary, devices)
+		if err != nil {
+			fmt.Println("Error saving mission", id, err)
+		}
+
+		s.Lock()
+		delete(s.pending, id)
+		s.Unlock()
+		close(done)
+	}()
+
+	return id, nil
+}
+
+func (s *MissionStore) write(id string, mission []byte, metadata []byte, analyzed []byte, summary MissionSummary, devices []map[*zip.FileHeader][]byte) error {
+	dir := filepath.Join(s.Dir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "mission.json"), mission, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "metadata.json"), metadata, 0644); err != nil {
+		return err
+	}
+	if analyzed != nil {
+		if err := ioutil.WriteFile(filepath.Join(dir, "analysis.json"), analyzed, 0644); err != nil {
+			return err
+		}
+	}
+
+	if s.Audit != nil {
+		audit, err := s.Audit.ArchiveFile(id)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, audit)
+	}
+
+	// Write to a temp file first, so a half written archive is never served.
+	tmp := filepath.Join(dir, "mission.zip.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	summary.Files, err = WriteMissionArchive(f, mission, devices, func(total int, complete int) {
+		if s.Progress != nil {
+			s.Progress(id, total, complete, nil)
+		}
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if s.Progress != nil {
+			s.Progress(id, summary.Files, summary.Files, err)
+		}
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "mission.zip")); err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "mission.zip")); err == nil {
+		summary.Size = fi.Size()
+	}
+	summary.Saved = time.Now().UnixNano()
+	return s.writeSummary(id, summary)
+}
+
+func (s *MissionStore) writeSummary(id string, summary MissionSummary) error {
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.Dir, id, "summary.json"), b, 0644)
+}
+
+// Blocks until any in-progress save of id is done.
+func (s *MissionStore) wait(id string) {
+	s.Lock()
+	done, ok := s.pending[id]
+	s.Unlock()
+
+	if ok {
+		<-done
+	}
+}
+
+func (s *MissionStore) path(id string, name string) (string, error) {
+	// IDs are numeric, which also keeps requests from wandering out of Dir.
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return "", ErrMissionNotFound
+	}
+	return filepath.Join(s.Dir, id, name), nil
+}
+
+// Summaries of every stored mission, most recent first.
+func (s *MissionStore) List() ([]MissionSummary, error) {
+	dirs, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]MissionSummary, 0)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		summary, err := s.Summary(d.Name())
+		if err == nil {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	sort.Slice(summaries, func(a, b int) bool { return summaries[a].Timestamp > summaries[b].Timestamp })
+	return summaries, nil
+}
+
+func (s *MissionStore) Summary(id string) (MissionSummary, error) {
+	var summary MissionSummary
+
+	p, err := s.path(id, "summary.json")
+	if err != nil {
+		return summary, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return summary, ErrMissionNotFound
+	} else if err != nil {
+		return summary, err
+	}
+	err = json.Unmarshal(b, &summary)
+	return summary, err
+}
+
+// The stored mission.json for id.
+func (s *MissionStore) Mission(id string) ([]byte, error) {
+	s.wait(id)
+
+	p, err := s.path(id, "mission.json")
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrMissionNotFound
+	}
+	return b, err
+}
+
+// The stored metadata for id.
+func (s *MissionStore) Metadata(id string) (MissionMetadata, error) {
+	var metadata MissionMetadata
+
+	s.wait(id)
+
+	p, err := s.path(id, "metadata.json")
+	if err != nil {
+		return metadata, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		// Missions saved before metadata was recorded.
+		if _, err := s.Summary(id); err != nil {
+			return metadata, err
+		}
+		return metadata, nil
+	} else if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(b, &metadata)
+	return metadata, err
+}
+
+// Replaces the metadata of a stored mission, in metadata.json, mission.json, the summary and the archive.
+func (s *MissionStore) SetMetadata(id string, metadata MissionMetadata) error {
+	if err := metadata.Validate(); err != nil {
+		return err
+	}
+
+	s.wait(id)
+
+	summary, err := s.Summary(id)
+	if err != nil {
+		return err
+	}
+
+	// One edit at a time.
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := metadata.marshal()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.Dir, id)
+	if err := ioutil.WriteFile(filepath.Join(dir, "metadata.json"), data, 0644); err != nil {
+		return err
+	}
+
+	// Patch the Metadata in mission.json, leaving everything else as it was recorded.
+	mission, err := ioutil.ReadFile(filepath.Join(dir, "mission.json"))
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(mission, &fields); err != nil {
+		return err
+	}
+	fields["Metadata"] = data
+	if mission, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "mission.json"), mission, 0644); err != nil {
+		return err
+	}
+
+	replace := map[string][]byte {
+		"mission.json": 	mission,
+		"metadata.json": 	data,
+	}
+	// Pick up anything done since the mission was saved.
+	if err := s.addAudit(id, replace); err != nil {
+		return err
+	}
+	if err := rewriteArchive(filepath.Join(dir, "mission.zip"), replace); err != nil {
+		return err
+	}
+
+	summary.Metadata = metadata
+	if fi, err := os.Stat(filepath.Join(dir, "mission.zip")); err == nil {
+		summary.Size = fi.Size()
+	}
+	return s.writeSummary(id, summary)
+}
+
+// Rewrites the audit.json of a stored mission, picking up actions recorded after it was saved, such as the abort
+// which ended it.
+func (s *MissionStore) RefreshAudit(id string) error {
+	if s.Audit == nil {
+		return nil
+	}
+
+	s.wait(id)
+
+	summary, err := s.Summary(id)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	replace := make(map[string][]byte)
+	if err := s.addAudit(id, replace); err != nil {
+		return err
+	}
+	path := filepath.Join(s.Dir, id, "mission.zip")
+	if err := rewriteArchive(path, replace); err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(path); err == nil {
+		summary.Size = fi.Size()
+	}
+	return s.writeSummary(id, summary)
+}
+
+// Adds the mission's audit.json to files being written to its archive.
+func (s *MissionStore) addAudit(id string, replace map[string][]byte) error {
+	if s.Audit == nil {
+		return nil
+	}
+	audit, err := s.Audit.ArchiveFile(id)
+	if err != nil {
+		return err
+	}
+	for fname, fdata := range audit {
+		replace[fname.Name] = fdata
+	}
+	return nil
+}
+
+// Rewrites the archive at path with the named files replaced (or added).
+func rewriteArchive(path string, replace map[string][]byte) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+
+	for _, zf := range zr.File {
+		if _, ok := replace[zf.Name]; ok {
+			continue
+		}
+		if err = copyArchiveFile(zw, zf); err != nil {
+			break
+		}
+	}
+	for name, data := range replace {
+		if err != nil {
+			break
+		}
+		var w io.Writer
+		w, err = zw.CreateHeader(&zip.FileHeader {
+			Name: 		name,
+			Modified: 	time.Now(),
+			Method: 	zip.Deflate,
+		})
+		if err == nil {
+			_, err = w.Write(data)
+		}
+	}
+
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// A single compressed archive entry.
+func archiveFile(name string, data []byte) map[*zip.FileHeader][]byte {
+	return map[*zip.FileHeader][]byte {
+		&zip.FileHeader {
+			Name: 		name,
+			Modified: 	time.Now(),
+			Method: 	zip.Deflate,
+		}: data,
+	}
+}
+
+// v as a JSON archive entry.
+func jsonArchiveFile(name string, v interface{}) (map[*zip.FileHeader][]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return archiveFile(name, b), nil
+}
+
+func copyArchiveFile(zw *zip.Writer, zf *zip.File) error {
+	header := zf.FileHeader
+	w, err := zw.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// Writes the archive at path to w, with the recorded scale masses converted to unit.
+func WriteArchiveIn(w io.Writer, path string, unit MassUnit) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	zw := zip.NewWriter(w)
+	for _, zf := range zr.File {
+		if zf.Name != "scale.json" {
+			if err = copyArchiveFile(zw, zf); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if data, err = ConvertSamples(data, unit); err != nil {
+			return err
+		}
+		header := zf.FileHeader
+		fw, err := zw.CreateHeader(&header)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Converts the masses in a scale.json recording to unit.
+func ConvertSamples(data []byte, unit MassUnit) ([]byte, error) {
+	var samples []Sample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, err
+	}
+	for idx := range samples {
+		if samples[idx].Volt0Newtons == nil {
+			samples[idx].calculateThrust()
+		}
+		samples[idx] = samples[idx].In(unit)
+	}
+	return json.Marshal(samples)
+}
+
+// The thrust analysis for id. Missions stored without one, and unfiltered analyses, are analyzed from the recorded
+// scale data.
+func (s *MissionStore) Analysis(id string, filtered bool) (analysis.Result, error) {
+	var result analysis.Result
+
+	s.wait(id)
+
+	p, err := s.path(id, "analysis.json")
+	if err != nil {
+		return result, err
+	}
+	if filtered {
+		b, err := ioutil.ReadFile(p)
+		if err == nil {
+			err = json.Unmarshal(b, &result)
+			return result, err
+		} else if !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+
+	archive, err := s.Archive(id)
+	if err != nil {
+		return result, err
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return result, err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != "scale.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return result, err
+		}
+		var samples []Sample
+		err = json.NewDecoder(rc).Decode(&samples)
+		rc.Close()
+		if err != nil {
+			return result, err
+		}
+		return AnalyzeSamples(samples, filtered)
+	}
+	return result, analysis.ErrNoBurn
+}
+
+// Path to the archive for id, waiting for it to be written if need be.
+func (s *MissionStore) Archive(id string) (string, error) {
+	s.wait(id)
+
+	p, err := s.path(id, "mission.zip")
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return "", ErrMissionNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Number of missions still being written.
+func (s *MissionStore) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.pending)
+}
+
+// ID of the most recently saved mission, or "" if none have been saved since startup.
+func (s *MissionStore) Latest() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.latest
+}
+
+func (s *MissionStore) Delete(id string) error {
+	s.wait(id)
+
+	p, err := s.path(id, "")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return ErrMissionNotFound
+	}
+
+	s.Lock()
+	if s.latest == id {
+		s.latest = ""
+	}
+	s.Unlock()
+
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,61 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestMissionStoreRefreshAudit(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMissionStore(filepath.Join(dir, "missions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Audit, err = NewAuditLog(filepath.Join(dir, "audit.log")); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Audit.Close()
+
+	m := NewMission(nil, nil, nil, DefaultMissionConfig(), MissionMetadata{})
+	store.Audit.Record(AuditEntry{ Action: "/mission/start", Mission: m.ID })
+	if _, err := store.Save(m, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Recorded once the abort request returns, after the mission was saved.
+	store.Audit.Record(AuditEntry{ Action: "/mission/abort", Mission: m.ID })
+
+	if err := store.RefreshAudit(m.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := store.Archive(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var entries []AuditEntry
+	for _, zf := range zr.File {
+		if zf.Name != "audit.json" {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(r).Decode(&entries)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(entries) != 2 || entries[1].Action != "/mission/abort" {
+		t.Fatalf("audit.json holds %+v", entries)
+	}
+}
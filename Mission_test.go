@@ -0,0 +1,367 @@
+package pi_launch_control
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Drives a Mission, with a simulated igniter and scale, on a clock which only moves when the test says so.
+type missionHarness struct {
+	t				*testing.T
+	clock			*ManualTimeSource
+	sim				*SimulatedIgniter
+	igniter			*Igniter
+	src				*SimulatedScaleSource
+	scale			*Scale
+	trigger			chan time.Time
+	mission			*Mission
+	// Ticks the clock has been moved on.
+	ticks			int
+}
+
+func newMissionHarness(t *testing.T, config MissionConfig) *missionHarness {
+	return newMissionHarnessIn(t, config, Grams)
+}
+
+// A harness whose scale is calibrated in unit. The simulated load is still in grams.
+func newMissionHarnessIn(t *testing.T, config MissionConfig, unit MassUnit) *missionHarness {
+	// Keep calibrations out of the real directory.
+	dir := ScaleCalibrationDir
+	ScaleCalibrationDir = ""
+	t.Cleanup(func() { ScaleCalibrationDir = dir })
+
+	h := &missionHarness {
+		t: 			t,
+		clock: 		NewManualTimeSource(time.Now()),
+		sim: 		NewSimulatedIgniter(DefaultSimulatedIgniterConfig()),
+		trigger: 	make(chan time.Time),
+	}
+
+	var err error
+	if h.igniter, err = h.sim.NewIgniter(); err != nil {
+		t.Fatal(err)
+	}
+	h.igniter.SetTimeSource(h.clock)
+	arming, err := h.sim.NewArming()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.igniter.SetArming(arming)
+	h.sim.SetKey(true)
+	waitFor(t, arming.KeyArmed)
+	if err = arming.Arm(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Samples are stamped from the harness clock, and the calibration matches the simulated load cell.
+	cfg := DefaultSimulatedScaleConfig()
+	cfg.Drift = 0
+	h.src = NewSimulatedScaleSource(cfg)
+	if h.scale, err = NewScaleFromSource("sim", h.src, h.trigger); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.scale.Close)
+	channel := newScaleChannel(true)
+	channel.ZeroOffset = int(cfg.Baseline)
+	// Calibrated with around a kilogram, in whole units.
+	known := int(Grams.Convert(1000, unit))
+	channel.Measured = map[int]int {
+		0: 		int(cfg.Baseline),
+		known: 	int(float64(cfg.Baseline) + unit.Convert(float64(known), Grams) * cfg.CountsPerMass),
+	}
+	if err = h.scale.SetCalibration(ScaleCalibration{ Unit: unit, Channels: []ScaleChannel{ channel } }); err != nil {
+		t.Fatal(err)
+	}
+
+	h.mission = NewMission(h.igniter, h.scale, nil, config, MissionMetadata{})
+	h.mission.SetTimeSource(h.clock)
+	return h
+}
+
+func (h *missionHarness) start() {
+	if err := h.mission.Start(context.Background(), nil); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// Reads a scale sample at the current time, under the given load.
+func (h *missionHarness) sample(load float64) {
+	h.src.SetLoad(load)
+	now := h.clock.Now()
+	h.trigger <- now
+	waitFor(h.t, func() bool { return h.scale.LastSample() >= now.UnixNano() })
+}
+
+// Samples the scale, then moves the clock on one tick and waits for the mission to run it.
+func (h *missionHarness) step(load float64) {
+	h.sample(load)
+
+	h.mission.Lock()
+	before := h.mission.Clock
+	config := h.mission.Config
+	armed := h.mission.Phase == PhaseArmed
+	h.mission.Unlock()
+
+	h.clock.Advance(missionDuration(config.Tick))
+	h.ticks++
+	if !config.Triggered() {
+		// The first tick reads -Countdown.
+		expected := -config.Countdown + float64(h.ticks - 1) * config.Tick
+		h.until(func(m *Mission) bool { return m.Clock >= expected || m.Phase.Final() })
+	} else if !armed {
+		// Until triggered, a triggered mission's clock doesn't move, so there's nothing to wait for.
+		h.until(func(m *Mission) bool { return m.Clock != before || m.Phase.Final() })
+	}
+}
+
+// Waits for cond to hold on the mission.
+func (h *missionHarness) until(cond func(m *Mission) bool) {
+	h.t.Helper()
+	waitFor(h.t, func() bool {
+		h.mission.Lock()
+		defer h.mission.Unlock()
+		return cond(h.mission)
+	})
+}
+
+func (h *missionHarness) clockReads() float64 {
+	h.mission.Lock()
+	defer h.mission.Unlock()
+
+	return h.mission.Clock
+}
+
+func (h *missionHarness) phases() []MissionPhase {
+	h.mission.Lock()
+	defer h.mission.Unlock()
+
+	phases := make([]MissionPhase, 0, len(h.mission.Transitions))
+	for _, t := range h.mission.Transitions {
+		phases = append(phases, t.To)
+	}
+	return phases
+}
+
+func (h *missionHarness) expectPhases(expected ...MissionPhase) {
+	h.t.Helper()
+	phases := h.phases()
+	if len(phases) != len(expected) {
+		h.t.Fatalf("phases %v, expected %v", phases, expected)
+	}
+	for idx := range expected {
+		if phases[idx] != expected[idx] {
+			h.t.Fatalf("phases %v, expected %v", phases, expected)
+		}
+	}
+}
+
+// Starts the mission and runs it through a 200 gram burn.
+func (h *missionHarness) runToCompletion() {
+	h.t.Helper()
+	h.start()
+
+	for i := 0; i < 40 && !h.mission.Finished(); i++ {
+		h.mission.Lock()
+		lit := h.mission.Phase == PhaseIgnition || h.mission.Phase == PhaseBurn
+		h.mission.Unlock()
+
+		load := 0.0
+		if lit {
+			load = 200
+		}
+		h.step(load)
+	}
+	<-h.mission.Done()
+
+	h.expectPhases(PhaseArmed, PhaseCountdown, PhaseIgnition, PhaseBurn, PhaseSafing, PhaseComplete)
+	if len(h.sim.FireWrites()) == 0 {
+		h.t.Fatal("igniter never fired")
+	}
+	if h.igniter.IsFiring() {
+		h.t.Fatal("fire pin left high")
+	}
+	if len(h.scale.RecordedSamples()) == 0 {
+		h.t.Fatal("scale didn't record")
+	}
+}
+
+func harnessConfig() MissionConfig {
+	c := DefaultMissionConfig()
+	c.Countdown = 2
+	c.PreRecord = 1
+	c.Tick = 0.5
+	c.PostBurn = 2
+	c.IgnitionWindow = 1
+	c.Lockout = 5
+	return c
+}
+
+func TestMissionCompletes(t *testing.T) {
+	h := newMissionHarness(t, harnessConfig())
+	h.runToCompletion()
+}
+
+// Thresholds are in newtons, so a scale calibrated in kilograms sees the same burn.
+func TestMissionCompletesInKilograms(t *testing.T) {
+	h := newMissionHarnessIn(t, harnessConfig(), Kilograms)
+	h.runToCompletion()
+}
+
+func TestMissionAbort(t *testing.T) {
+	h := newMissionHarness(t, harnessConfig())
+	h.start()
+	h.step(0)
+	h.step(0)
+
+	if err := h.mission.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	// Abort returns once everything is safed.
+	select {
+	case <-h.mission.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("mission not done after abort")
+	}
+
+	h.expectPhases(PhaseArmed, PhaseCountdown, PhaseAborted)
+	h.mission.Lock()
+	cause := h.mission.AbortCause
+	h.mission.Unlock()
+	if cause == nil || cause.Reason != AbortOperator {
+		t.Fatalf("abort cause %+v", cause)
+	}
+	if len(h.sim.FireWrites()) != 0 {
+		t.Fatal("igniter fired after abort")
+	}
+}
+
+func TestMissionHangfireLockout(t *testing.T) {
+	config := harnessConfig()
+	h := newMissionHarness(t, config)
+	h.start()
+
+	// The motor never lights.
+	for i := 0; i < 40; i++ {
+		h.step(0)
+		h.mission.Lock()
+		hangfire := h.mission.Hangfire != nil
+		h.mission.Unlock()
+		if hangfire {
+			break
+		}
+	}
+	h.expectPhases(PhaseArmed, PhaseCountdown, PhaseIgnition, PhaseSafing)
+
+	// Locked out on the mission's clock, however long it really takes.
+	if !h.igniter.LockedOut() {
+		t.Fatal("igniter not locked out after hangfire")
+	}
+	if err := h.igniter.Fire(); err == nil {
+		t.Fatal("igniter fired while locked out")
+	}
+	if h.igniter.Arming().IsArmed() {
+		t.Fatal("still armed after hangfire")
+	}
+	if err := h.igniter.Arming().Arm(); err == nil {
+		t.Fatal("re-armed while locked out")
+	}
+
+	// Recording stops once a burn would have been over, well before the lockout ends.
+	for h.clockReads() < config.IgnitionWindow + config.PostBurn + config.Tick {
+		h.step(0)
+	}
+	if !h.igniter.LockedOut() {
+		t.Fatal("lockout over before recording stopped")
+	}
+	recorded := len(h.scale.RecordedSamples())
+	h.step(0)
+	if len(h.scale.RecordedSamples()) != recorded {
+		t.Fatal("still recording during the lockout")
+	}
+
+	for i := 0; i < 40 && !h.mission.Finished(); i++ {
+		h.step(0)
+	}
+	<-h.mission.Done()
+
+	if h.igniter.LockedOut() {
+		t.Fatal("igniter still locked out after the lockout")
+	}
+	if h.igniter.Arming().IsArmed() {
+		t.Fatal("armed again without anyone arming")
+	}
+	h.expectPhases(PhaseArmed, PhaseCountdown, PhaseIgnition, PhaseSafing, PhaseAborted)
+	h.mission.Lock()
+	defer h.mission.Unlock()
+	if h.mission.AbortCause == nil || h.mission.AbortCause.Reason != AbortHangfire {
+		t.Fatalf("abort cause %+v", h.mission.AbortCause)
+	}
+	if h.mission.Clock < config.Lockout {
+		t.Fatalf("hangfire released at %.1f, before the %.0f second lockout", h.mission.Clock, config.Lockout)
+	}
+}
+
+func triggeredConfig(t *testing.T) MissionConfig {
+	config := harnessConfig()
+	config.Mode = MissionTriggered
+	config.PreTrigger = 1
+	config.ThrustThreshold = 1
+	config.ReleaseThreshold = 0.5
+	config.ReleaseTime = 1
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// Waits out a triggered mission through a 300 gram burn.
+func (h *missionHarness) runTriggered() {
+	h.t.Helper()
+	h.start()
+
+	for i := 0; i < 4; i++ {
+		h.step(0)
+	}
+	h.expectPhases(PhaseArmed)
+
+	h.step(300)
+	h.until(func(m *Mission) bool { return m.Phase == PhaseBurn })
+	h.step(300)
+	for i := 0; i < 40 && !h.mission.Finished(); i++ {
+		h.step(0)
+	}
+	<-h.mission.Done()
+
+	h.expectPhases(PhaseArmed, PhaseBurn, PhaseSafing, PhaseComplete)
+}
+
+func TestMissionTriggered(t *testing.T) {
+	h := newMissionHarness(t, triggeredConfig(t))
+	h.runTriggered()
+
+	if len(h.sim.FireWrites()) != 0 {
+		t.Fatal("triggered mission fired the igniter")
+	}
+}
+
+// A hand-lit motor, on a stand with no igniter.
+func TestMissionTriggeredWithoutIgniter(t *testing.T) {
+	config := triggeredConfig(t)
+	h := newMissionHarness(t, config)
+	h.mission = NewMission(nil, h.scale, nil, config, MissionMetadata{})
+	h.mission.SetTimeSource(h.clock)
+	h.runTriggered()
+
+	if len(h.scale.RecordedSamples()) == 0 {
+		t.Fatal("scale didn't record")
+	}
+}
+
+func TestMissionCountdownNeedsIgniter(t *testing.T) {
+	h := newMissionHarness(t, harnessConfig())
+	h.mission = NewMission(nil, h.scale, nil, harnessConfig(), MissionMetadata{})
+	if err := h.mission.Start(context.Background(), nil); err == nil {
+		t.Fatal("countdown started without an igniter")
+	}
+}
@@ -0,0 +1,265 @@
+package pi_launch_control
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPass	PreflightStatus = "pass"
+	PreflightWarn	PreflightStatus = "warn"
+	PreflightFail	PreflightStatus = "fail"
+)
+
+// Worse statuses rank higher.
+func (s PreflightStatus) rank() int {
+	switch s {
+	case PreflightFail:
+		return 2
+	case PreflightWarn:
+		return 1
+	}
+	return 0
+}
+
+// The result of a single preflight check.
+//
+// swagger:model
+type PreflightCheck struct {
+	Name			string
+	Status			PreflightStatus
+	Message			string
+}
+
+// The result of every preflight check.
+//
+// swagger:model
+type PreflightReport struct {
+	Timestamp		int64
+	// The worst status of all the checks.
+	Status			PreflightStatus
+	Checks			[]PreflightCheck
+	// The mission was started in spite of failed checks.
+	Override		bool
+}
+
+// Name of the igniter check, which triggered missions don't need to pass since they never fire.
+const PreflightIgniterCheck = "Igniter"
+
+// Checks which failed, leaving out any named in skip.
+func (r PreflightReport) Failed(skip ...string) []PreflightCheck {
+	failed := make([]PreflightCheck, 0)
+	for _, c := range r.Checks {
+		if c.Status != PreflightFail {
+			continue
+		}
+		skipped := false
+		for _, name := range skip {
+			skipped = skipped || c.Name == name
+		}
+		if !skipped {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Anything earlier than this is a clock which has never been set.
+var preflightMinimumClock = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Values from <linux/timex.h>.
+const (
+	adjtimexTimeError	= 5
+	adjtimexStaUnsync	= 0x0040
+)
+
+// Checks the test stand is ready before a mission starts.
+type Preflight struct {
+	sync.Mutex
+
+	// Where missions are saved. Its free space is checked.
+	Store				*MissionStore
+	// Calibrations older than this are flagged.
+	MaxCalibrationAge	time.Duration
+	// Missions can't start with less free space than this, in bytes. Less than 4x this is a warning.
+	MinFreeSpace		uint64
+
+	clockSet			bool
+	downloaded			int64
+}
+
+func NewPreflight(store *MissionStore) *Preflight {
+	return &Preflight {
+		Store: 				store,
+		MaxCalibrationAge: 	24 * time.Hour,
+		MinFreeSpace: 		100 * 1024 * 1024,
+	}
+}
+
+// Records that the clock has been set (by a client) since startup.
+func (p *Preflight) ClockSet() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.clockSet = true
+}
+
+// Records that the recorded data starting at timestamp (unix seconds, as the igniter records) has been downloaded.
+func (p *Preflight) Downloaded(timestamp int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.downloaded = timestamp
+}
+
+// Runs every check against the given devices.
+func (p *Preflight) Run(igniter *Igniter, scale *Scale, camera *Camera) PreflightReport {
+	report := PreflightReport {
+		Timestamp: 	time.Now().UnixNano(),
+		Status: 	PreflightPass,
+		Checks: 	[]PreflightCheck {
+			p.checkIgniter(igniter),
+			p.checkScale(scale),
+			p.checkCalibration(scale),
+			p.checkCamera(camera),
+			p.checkClock(),
+			p.checkDisk(),
+			p.checkData(igniter),
+		},
+	}
+
+	for _, c := range report.Checks {
+		if c.Status.rank() > report.Status.rank() {
+			report.Status = c.Status
+		}
+	}
+	return report
+}
+
+func (p *Preflight) checkIgniter(igniter *Igniter) PreflightCheck {
+	c := PreflightCheck{ Name: PreflightIgniterCheck, Status: PreflightPass, Message: "Igniter continuity OK" }
+	switch {
+	case igniter == nil:
+		c.Status, c.Message = PreflightFail, "Igniter not present"
+	case igniter.LockedOut():
+		c.Status = PreflightFail
+		c.Message = fmt.Sprintf("Igniter locked out for %.0f seconds", igniter.LockoutRemaining().Seconds())
+	case !igniter.IsReady():
+		c.Status, c.Message = PreflightFail, "No igniter continuity"
+	}
+	return c
+}
+
+func (p *Preflight) checkScale(scale *Scale) PreflightCheck {
+	c := PreflightCheck{ Name: "Scale", Status: PreflightPass, Message: "Scale initialized and tared" }
+	if scale == nil || !scale.IsInitialized() {
+		c.Status, c.Message = PreflightFail, "Scale not initialized"
+		return c
+	}
+
+	if !scale.Tared() {
+		c.Status, c.Message = PreflightWarn, "Scale has not been tared this session"
+	}
+	return c
+}
+
+func (p *Preflight) checkCalibration(scale *Scale) PreflightCheck {
+	c := PreflightCheck{ Name: "Calibration", Status: PreflightPass, Message: "Scale calibrated" }
+	if scale == nil || !scale.IsInitialized() {
+		c.Status, c.Message = PreflightWarn, "Scale not initialized"
+		return c
+	}
+
+	calibrated := scale.IsCalibrated()
+	at := scale.CalibratedAt()
+
+	if !calibrated {
+		c.Status, c.Message = PreflightWarn, "Scale not calibrated, thrust won't be measured and hangfires can't be detected"
+	} else if age := time.Since(time.Unix(0, at)); p.MaxCalibrationAge > 0 && age > p.MaxCalibrationAge {
+		c.Status = PreflightWarn
+		c.Message = fmt.Sprintf("Scale calibrated %s ago", age.Round(time.Minute))
+	}
+	return c
+}
+
+func (p *Preflight) checkCamera(camera *Camera) PreflightCheck {
+	c := PreflightCheck{ Name: "Camera", Status: PreflightPass, Message: "Camera streaming" }
+	if camera == nil || !camera.IsInitialized() {
+		c.Status, c.Message = PreflightWarn, "Camera not initialized, no video will be recorded"
+	} else if since := time.Since(camera.LastFrame()); since > time.Second {
+		c.Status, c.Message = PreflightWarn, "No camera frames received recently"
+	}
+	return c
+}
+
+func (p *Preflight) checkClock() PreflightCheck {
+	c := PreflightCheck{ Name: "Clock", Status: PreflightPass, Message: "Clock set" }
+
+	p.Lock()
+	set := p.clockSet
+	p.Unlock()
+
+	if time.Now().Before(preflightMinimumClock) {
+		c.Status, c.Message = PreflightFail, "Clock has not been set"
+	} else if !set && !clockSynchronized() {
+		c.Status, c.Message = PreflightWarn, "Clock has not been set or synchronized since startup"
+	}
+	return c
+}
+
+// True if the kernel considers the clock synchronized (by NTP).
+func clockSynchronized() bool {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	return err == nil && state != adjtimexTimeError && tx.Status & adjtimexStaUnsync == 0
+}
+
+func (p *Preflight) checkDisk() PreflightCheck {
+	c := PreflightCheck{ Name: "Disk", Status: PreflightPass }
+	if p.Store == nil {
+		c.Status, c.Message = PreflightWarn, "Mission storage not available, missions will not be saved"
+		return c
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(p.Store.Dir, &fs); err != nil {
+		c.Status, c.Message = PreflightFail, err.Error()
+		return c
+	}
+	free := fs.Bavail * uint64(fs.Bsize)
+
+	c.Message = fmt.Sprintf("%d MiB free", free / (1024 * 1024))
+	if free < p.MinFreeSpace {
+		c.Status = PreflightFail
+	} else if free < p.MinFreeSpace * 4 {
+		c.Status = PreflightWarn
+	}
+	return c
+}
+
+func (p *Preflight) checkData(igniter *Igniter) PreflightCheck {
+	c := PreflightCheck{ Name: "Data", Status: PreflightPass, Message: "No mission data pending download" }
+
+	// Stored missions are safe, so long as they've finished saving.
+	if p.Store != nil {
+		if n := p.Store.Pending(); n > 0 {
+			c.Status, c.Message = PreflightWarn, fmt.Sprintf("%d missions still saving", n)
+		}
+		return c
+	}
+
+	if igniter == nil {
+		return c
+	}
+	p.Lock()
+	downloaded := p.downloaded
+	p.Unlock()
+	if first := igniter.GetFirstRecorded(); first != nil && first.Timestamp != downloaded {
+		c.Status, c.Message = PreflightWarn, "Recorded mission data has not been downloaded, and will be lost"
+	}
+	return c
+}
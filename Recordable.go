@@ -1,11 +1,28 @@
 package pi_launch_control
 
-import "archive/zip"
+import (
+	"archive/zip"
+	"time"
+)
+
+// Longest pre-trigger window a Recordable keeps history for.
+const MaxPreTrigger = 10 * time.Second
+
+func clampPreTrigger(window time.Duration) time.Duration {
+	if window < 0 {
+		return 0
+	} else if window > MaxPreTrigger {
+		return MaxPreTrigger
+	}
+	return window
+}
 
 type Recordable interface {
 	StartRecording()
 	StopRecording()
 	ResetRecording()
+	// How far back StartRecording reaches into what was captured before it, zero for nothing.
+	SetPreTrigger(window time.Duration)
 
 	GetRecordedData() map[*zip.FileHeader][]byte
 }
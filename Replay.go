@@ -0,0 +1,355 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Seconds before T-0 that a Mission begins recording, for archives without a mission.json.
+const replayPreRoll = 3
+
+// How often replayed events are emitted.
+const replayInterval = 50 * time.Millisecond
+
+// Emits events on behalf of a device during a replay.
+type replayChannel string
+
+func (c replayChannel) eventName() string {
+	return string(c)
+}
+
+type replayFrame struct {
+	Timestamp		int64
+	Data			[]byte
+}
+
+// Replays a downloaded mission archive through the event stream.
+//
+// swagger:model
+type Replay struct {
+	Emitter					`json:"-"`
+	sync.Mutex				`json:"-"`
+
+	Name			string
+	// Wall clock of the first recorded data, in unix nanoseconds.
+	Start			int64
+	// Length of the recording.
+	Duration		time.Duration
+	// Offset from Start of the playback.
+	Position		time.Duration
+	Speed			float64
+	Playing			bool
+	Paused			bool
+
+	// The recorded mission, if the archive has one.
+	Mission			*Mission
+
+	igniterStates	[]IgniterState
+	samples			[]Sample
+	frames			[]replayFrame
+
+	igniter			Emitter
+	scale			Emitter
+	mission			Emitter
+	broker			*Broker
+	camera			*Camera
+	muted			[]*Emitter
+
+	ticker			*time.Ticker
+	done			chan bool
+}
+
+// Loads a mission archive as produced by /mission/download.
+func NewReplay(name string, archive []byte) (*Replay, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay {
+		Name: 	name,
+		Speed: 	1,
+	}
+	r.EmitterID = r
+	r.igniter.EmitterID = replayChannel("Igniter")
+	r.scale.EmitterID = replayChannel("Scale")
+	r.mission.EmitterID = replayChannel("Mission")
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Base(f.Name)
+		switch {
+		case name == "igniter.json":
+			err = json.Unmarshal(data, &r.igniterStates)
+		case name == "mission.json":
+			err = json.Unmarshal(data, &r.Mission)
+		case name == "scale.json":
+			err = json.Unmarshal(data, &r.samples)
+		case isFrameName(name):
+			ts, perr := strconv.ParseInt(strings.TrimSuffix(name, path.Ext(name)), 10, 64)
+			if perr == nil {
+				r.frames = append(r.frames, replayFrame{ts, data})
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(r.igniterStates) == 0 && len(r.samples) == 0 && len(r.frames) == 0 {
+		return nil, errors.New("archive contains no mission data")
+	}
+
+	// Igniter states are stamped in seconds.
+	for idx := range r.igniterStates {
+		r.igniterStates[idx].Timestamp *= int64(time.Second)
+	}
+	sort.Slice(r.igniterStates, func(a, b int) bool { return r.igniterStates[a].Timestamp < r.igniterStates[b].Timestamp })
+	sort.Slice(r.samples, func(a, b int) bool { return r.samples[a].Timestamp < r.samples[b].Timestamp })
+	sort.Slice(r.frames, func(a, b int) bool { return r.frames[a].Timestamp < r.frames[b].Timestamp })
+
+	var end int64 = 0
+	r.Start = -1
+	bounds := func(first int64, last int64) {
+		if r.Start < 0 || first < r.Start {
+			r.Start = first
+		}
+		if last > end {
+			end = last
+		}
+	}
+	if n := len(r.igniterStates); n > 0 {
+		bounds(r.igniterStates[0].Timestamp, r.igniterStates[n - 1].Timestamp)
+	}
+	if n := len(r.samples); n > 0 {
+		bounds(r.samples[0].Timestamp, r.samples[n - 1].Timestamp)
+	}
+	if n := len(r.frames); n > 0 {
+		bounds(r.frames[0].Timestamp, r.frames[n - 1].Timestamp)
+	}
+	r.Duration = time.Duration(end - r.Start)
+
+	return r, nil
+}
+
+func (r *Replay) eventName() string {
+	return "Replay"
+}
+
+// Begins playback, muting the live devices for the duration.
+// Frames are streamed to camera clients if camera is non-nil.
+func (r *Replay) Play(broker *Broker, camera *Camera, mute ...*Emitter) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.Playing {
+		r.Paused = false
+		r.Emit(r)
+		return
+	}
+
+	if r.broker != broker {
+		r.broker = broker
+		r.AddListener(broker.Outgoing)
+		r.igniter.AddListener(broker.Outgoing)
+		r.scale.AddListener(broker.Outgoing)
+		r.mission.AddListener(broker.Outgoing)
+	}
+	r.camera = camera
+
+	r.muted = nil
+	for _, e := range mute {
+		if e != nil && !e.Muted() {
+			e.Mute(true)
+			r.muted = append(r.muted, e)
+		}
+	}
+
+	if r.Position >= r.Duration {
+		r.Position = 0
+	}
+	r.Playing = true
+	r.Paused = false
+	r.ticker = time.NewTicker(replayInterval)
+	r.done = make(chan bool)
+	go r.playback(r.ticker, r.done)
+
+	r.Emit(r)
+}
+
+func (r *Replay) Pause() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.Paused = true
+	r.Emit(r)
+}
+
+// Sets the playback speed, 1 being real time.
+func (r *Replay) SetSpeed(speed float64) error {
+	if speed <= 0 {
+		return errors.New("speed must be positive")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.Speed = speed
+	r.Emit(r)
+	return nil
+}
+
+// Moves the playback position.
+func (r *Replay) Seek(position time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	if position < 0 {
+		position = 0
+	}
+	if position > r.Duration {
+		position = r.Duration
+	}
+	r.Position = position
+	r.Emit(r)
+}
+
+// Ends playback and un-mutes the live devices.
+func (r *Replay) Stop() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.stop()
+}
+
+func (r *Replay) stop() {
+	if !r.Playing {
+		return
+	}
+
+	r.ticker.Stop()
+	close(r.done)
+	r.Playing = false
+	r.Paused = false
+
+	for _, e := range r.muted {
+		e.Mute(false)
+	}
+	r.muted = nil
+
+	r.Emit(r)
+}
+
+func (r *Replay) playback(ticker *time.Ticker, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r.advance()
+		}
+	}
+}
+
+// Moves the playback position forward one interval and emits everything recorded within it.
+func (r *Replay) advance() {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.Playing || r.Paused {
+		return
+	}
+
+	from := r.Start + int64(r.Position)
+	r.Position += time.Duration(float64(replayInterval) * r.Speed)
+	if r.Position > r.Duration {
+		r.Position = r.Duration
+	}
+	to := r.Start + int64(r.Position)
+	last := r.Position == r.Duration
+	within := func(ts int64) bool {
+		return ts >= from && (ts < to || (last && ts == to))
+	}
+
+	for _, state := range r.igniterStates {
+		if within(state.Timestamp) {
+			r.igniter.Emit(state)
+		}
+	}
+
+	samples := make([]Sample, 0)
+	for _, sample := range r.samples {
+		if within(sample.Timestamp) {
+			samples = append(samples, sample)
+		}
+	}
+	r.scale.Emit(samples)
+
+	// Only the latest frame in the interval, we can't stream faster than that anyway.
+	if r.camera != nil {
+		var frame []byte = nil
+		for _, f := range r.frames {
+			if within(f.Timestamp) {
+				frame = f.Data
+			}
+		}
+		if frame != nil {
+			go r.camera.ReplayFrame(frame)
+		}
+	}
+
+	// Reconstruct the mission clock on each whole second.
+	second := int64(time.Second)
+	if from == r.Start || last || (to - r.Start) / second != (from - r.Start) / second {
+		preRoll := float64(replayPreRoll)
+		if r.Mission != nil {
+			preRoll = r.Mission.Config.PreRecord + r.Mission.Config.PreTrigger
+			if r.Mission.Config.Triggered() {
+				preRoll = r.Mission.Config.PreTrigger
+			}
+		}
+		clock := float64((to - r.Start) / second) - preRoll
+		phase := PhaseCountdown
+		var cause *MissionAbort = nil
+		if last {
+			phase = PhaseComplete
+			if r.Mission != nil && r.Mission.Aborted {
+				phase = PhaseAborted
+				cause = r.Mission.AbortCause
+			}
+		} else if clock >= 0 {
+			phase = PhaseBurn
+		}
+		r.mission.Emit(&Mission {
+			Timestamp: 	r.Start,
+			Clock: 		clock,
+			Phase: 		phase,
+			Aborted: 	phase == PhaseAborted,
+			AbortCause: cause,
+			Complete: 	phase == PhaseComplete,
+		})
+		r.Emit(r)
+	}
+
+	if r.Position == r.Duration {
+		r.stop()
+	}
+}
@@ -4,13 +4,11 @@ import (
 	"archive/zip"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/zfjagann/golang-ring"
 	"io"
-	"io/ioutil"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -20,8 +18,9 @@ import (
 //
 // swagger:model
 type Scale struct {
-	TriggerC		<- chan time.Time `json:"-"'`
-	readTic			time.Ticker `json:"-"`
+	TriggerC		<- chan time.Time `json:"-"`
+	readTic			*time.Ticker `json:"-"`
+	source			ScaleSource `json:"-"`
 	Emitter			`json:"-"`
 	sync.Mutex		`json:"-"`
 	Recordable		`json:"-"`
@@ -31,22 +30,26 @@ type Scale struct {
 	Device			string
 	Trigger			string
 
-	iIODevice  		string
-	devDevice  		string
-	idxTime    		int
-	idxVoltage 		int
-
 	Initialized		bool
+	// Every enabled channel is calibrated.
 	Calibrated 		bool
 	Recording 		bool
-	// Zero Offset (tare) threshold
-	ZeroOffset 		int
-	// Known measured values.
-	Measured   		map[int]int
-	// The adjustment scale value.
-	Adjust     		float64
+	// Unit the calibration masses are in.
+	Unit			MassUnit
+	// Volt0 and Volt1.
+	Channels		[ScaleChannels]ScaleChannel
 
 	recordedSamples []Sample
+	// The filter the recorded samples were taken with.
+	recordedFilter	ScaleFilter
+	preTrigger		time.Duration
+
+	filter			ScaleFilter
+	pipeline		*filterPipeline
+
+	// Guards the state copied into each sample, since the read loop can't wait on the scale's lock
+	// while Tare() and Calibrate() hold it waiting on samples.
+	settings		sync.RWMutex
 }
 
 // Representation of a Scale Measurement
@@ -54,158 +57,268 @@ type Scale struct {
 // swagger:model
 type Sample struct {
 	Initialized bool
+	// Every enabled channel is calibrated, so Mass is available.
 	Calibrated  bool
 	Recording   bool
+	// Channel 0 calibration. Adjust is zero while the channel is uncalibrated.
 	ZeroOffset	int
 	Adjust		float64
+	Quadratic	float64 `json:",omitempty"`
+	// Channel 1 calibration, zero unless the channel is enabled and calibrated.
+	Volt1ZeroOffset	int `json:",omitempty"`
+	Volt1Adjust		float64 `json:",omitempty"`
+	Volt1Quadratic	float64 `json:",omitempty"`
 	Timestamp	int64
 	Volt0		uint32
 	Volt0Mass	*float64
 	Volt1		uint32
 	Volt1Mass	*float64
+	// Filtered counts, when the scale has a filter. Masses come from these rather than Volt0 and Volt1.
+	Volt0Filtered	*float64 `json:",omitempty"`
+	Volt1Filtered	*float64 `json:",omitempty"`
+	// Weighted combination of the enabled channels' masses.
+	Mass		*float64 `json:",omitempty"`
+	// Combined mass from the unfiltered counts, when the scale has a filter.
+	RawMass		*float64 `json:",omitempty"`
+	// Unit Volt0Mass, Volt1Mass, Mass and RawMass are in.
+	Unit		MassUnit `json:",omitempty"`
+	// Thrust on each channel, and combined, in newtons and pound-force.
+	Volt0Newtons		*float64 `json:",omitempty"`
+	Volt0PoundsForce	*float64 `json:",omitempty"`
+	Volt1Newtons		*float64 `json:",omitempty"`
+	Volt1PoundsForce	*float64 `json:",omitempty"`
+	Newtons				*float64 `json:",omitempty"`
+	PoundsForce			*float64 `json:",omitempty"`
 }
 
 
-func (s *Sample) CalculateMass() {
-	if s.Calibrated {
-		v0m := float64(int(s.Volt0)-s.ZeroOffset) / s.Adjust
-		v1m := float64(int(s.Volt1)-s.ZeroOffset) / s.Adjust
+// Calculates the mass on each calibrated channel, and combines them with the given channel weights. Channels with
+// no weight are left out of the combined mass.
+func (s *Sample) CalculateMass(weights [ScaleChannels]float64) {
+	v0, v1 := float64(s.Volt0), float64(s.Volt1)
+	if s.Volt0Filtered != nil {
+		v0 = *s.Volt0Filtered
+	}
+	if s.Volt1Filtered != nil {
+		v1 = *s.Volt1Filtered
+	}
+	s.Volt0Mass, s.Volt1Mass, s.Mass = s.masses(v0, v1, weights)
 
-		s.Volt0Mass = &v0m
-		s.Volt1Mass = &v1m
-	} else {
-		s.Volt0Mass = nil;
-		s.Volt1Mass = nil;
+	s.RawMass = nil
+	if s.Volt0Filtered != nil || s.Volt1Filtered != nil {
+		_, _, s.RawMass = s.masses(float64(s.Volt0), float64(s.Volt1), weights)
 	}
+	s.calculateThrust()
 }
 
-func NewScale(dev string, trig <- chan time.Time, triggerDev string) (*Scale, error) {
-	var err error = nil
-
-	s := new(Scale)
-	s.TriggerC = trig
-	s.previousRead = 0
-	s.EmitterID = s
-	s.Device = dev
-	s.Trigger = triggerDev
-	s.Recording = false
-
-	// Test to make sure the scale device exist.
-	if _, err := os.Stat(dev); err != nil {
-		return nil, err
+func (s *Sample) masses(v0 float64, v1 float64, weights [ScaleChannels]float64) (m0 *float64, m1 *float64, total *float64) {
+	if s.Adjust != 0 {
+		v0m := countsToMass(v0 - float64(s.ZeroOffset), s.Adjust, s.Quadratic)
+		m0 = &v0m
 	}
-
-	files, err := ioutil.ReadDir(dev)
-	if err != nil {
-		return nil, err
+	if s.Volt1Adjust != 0 {
+		v1m := countsToMass(v1 - float64(s.Volt1ZeroOffset), s.Volt1Adjust, s.Volt1Quadratic)
+		m1 = &v1m
+	}
+	if !s.Calibrated {
+		return m0, m1, nil
 	}
 
-	for _, f := range files {
-		if strings.HasPrefix(f.Name(), "iio:device") {
-			s.iIODevice = dev + "/" + f.Name()
-			s.devDevice = "/dev/" + f.Name()
-			break
+	channels := [ScaleChannels]*float64{ m0, m1 }
+	var mass float64
+	for ch, weight := range weights {
+		if weight == 0 {
+			continue
 		}
+		if channels[ch] == nil {
+			return m0, m1, nil
+		}
+		mass += weight * *channels[ch]
 	}
+	return m0, m1, &mass
+}
 
+// A copy of the sample as it would be without the scale's filter, with masses from the raw counts.
+func (s Sample) Unfiltered() Sample {
+	if s.Volt0Filtered == nil && s.Volt1Filtered == nil {
+		return s
+	}
 
-	// If the sysfs trigger doesn't exist, then we try to create one.
-	if _, err := os.Stat(triggerDev); err != nil {
+	s.Volt0Filtered, s.Volt1Filtered = nil, nil
+	s.Volt0Mass, s.Volt1Mass, _ = s.masses(float64(s.Volt0), float64(s.Volt1), [ScaleChannels]float64{})
+	s.Mass, s.RawMass = s.RawMass, nil
+	s.calculateThrust()
+	return s
+}
 
-		// Make sure we have the proper sysfs bits.
-		if _, err := os.Stat("/sys/bus/iio/devices/iio_sysfs_trigger"); err != nil {
-			fmt.Println("Sysfs Triggering Unavilable.", err)
-			return s, err
-		}
+func (s *Sample) channelMass(channel int) *float64 {
+	if channel == 1 {
+		return s.Volt1Mass
+	}
+	return s.Volt0Mass
+}
 
-		// Create trigger0 if it doesn't exist.
-		if _, err := os.Stat("/sys/bus/iio/devices/iio_sysfs_trigger/trigger0"); err != nil {
-			// Create trigger0 since it does not exist
-			if err := deviceEcho("/sys/bus/iio/devices/iio_sysfs_trigger/add_trigger", []byte("0"), 0200); err != nil {
-				return s, err
-			}
-		}
+// Fills in thrust from the masses.
+func (s *Sample) calculateThrust() {
+	s.Volt0Newtons, s.Volt0PoundsForce = s.thrust(s.Volt0Mass)
+	s.Volt1Newtons, s.Volt1PoundsForce = s.thrust(s.Volt1Mass)
+	s.Newtons, s.PoundsForce = s.thrust(s.Mass)
+}
+
+// The combined mass, or the channel 0 mass for samples recorded before channels were combined.
+func (s *Sample) TotalMass() *float64 {
+	if s.Mass != nil {
+		return s.Mass
 	}
+	return s.Volt0Mass
+}
 
-	// By the time we get here we know we have sysfstrigger0
-	triggerName, err := ioutil.ReadFile(triggerDev + "/name")
+func (s *Sample) thrust(mass *float64) (*float64, *float64) {
+	if mass == nil {
+		return nil, nil
+	}
+	unit := s.Unit
+	if unit == "" {
+		unit = Grams
+	}
+	n, lbf := unit.Newtons(*mass), unit.PoundsForce(*mass)
+	return &n, &lbf
+}
 
-	// Disable the buffer and Set the trigger as the iio:device trigger.
-	err = deviceEcho(s.iIODevice + "/buffer/enable", []byte("0"), 0)
-	if err != nil {
-		return s, err
+// A copy of the sample with masses, and the calibration, in another unit. Thrust is unchanged.
+func (s Sample) In(unit MassUnit) Sample {
+	from := s.Unit
+	if from == "" {
+		from = Grams
 	}
-	if err := deviceEcho(s.iIODevice + "/trigger/current_trigger", triggerName, 0); err != nil {
-		return s, err
+	convert := func(mass *float64) *float64 {
+		if mass == nil {
+			return nil
+		}
+		m := from.Convert(*mass, unit)
+		return &m
 	}
 
-	// Get the timestamp and the voltage0
-	deviceEcho(s.iIODevice + "/scan_elements/in_timestamp_en", []byte("1"), 0644)
-	deviceEcho(s.iIODevice + "/scan_elements/in_voltage0_en", []byte("1"), 0644)
+	s.Volt0Mass = convert(s.Volt0Mass)
+	s.Volt1Mass = convert(s.Volt1Mass)
+	s.Mass = convert(s.Mass)
+	s.RawMass = convert(s.RawMass)
+	// Counts per unit scale with the size of the unit.
+	ratio := from.Convert(1, unit)
+	s.Adjust /= ratio
+	s.Quadratic /= ratio * ratio
+	s.Volt1Adjust /= ratio
+	s.Volt1Quadratic /= ratio * ratio
+	s.Unit = unit
+	return s
+}
 
-	// Find out what index the items are.
-	buf, err := ioutil.ReadFile(s.iIODevice + "/scan_elements/in_timestamp_index")
-	if err != nil {
-		return s, err
-	}
-	s.idxTime, err = strconv.Atoi(string(buf))
+func NewScale(dev string, trig <- chan time.Time, triggerDev string) (*Scale, error) {
+	return NewScaleAt(DefaultSysfsRoot, DefaultDevfsRoot, dev, trig, triggerDev)
+}
 
-	buf, err = ioutil.ReadFile(s.iIODevice + "/scan_elements/in_voltage0_index")
-	if err != nil {
-		return s, err
+// Creates a Scale for an IIO device, with sysfs and devfs mounted at the given roots.
+func NewScaleAt(sysfsRoot string, devfsRoot string, dev string, trig <- chan time.Time, triggerDev string) (*Scale, error) {
+	// Test to make sure the scale device exist.
+	if _, err := os.Stat(filepath.Join(sysfsRoot, strings.TrimPrefix(dev, DefaultSysfsRoot))); err != nil {
+		return nil, err
 	}
-	s.idxVoltage, err = strconv.Atoi(string(buf))
 
-	s.ZeroOffset = -1
-	s.Measured = make(map[int]int)
-	s.Adjust = 0
+	s := newScale(dev, trig)
+	s.Trigger = triggerDev
 
-	// Go ahead and start reading....
-	err = deviceEcho(s.iIODevice + "/buffer/enable", []byte("1"), 0)
+	src, err := NewIIOScaleSourceAt(sysfsRoot, devfsRoot, dev, triggerDev, s.enabledChannels()...)
 	if err != nil {
 		return s, err
 	}
 
-	// Attempt to open the device.
-	s.samples.SetCapacity(80 * 60) // 80 samples / second & average test length
+	return s, s.start(src)
+}
 
-	fd, err := os.Open(s.devDevice)
-	if err != nil {
-		return s, err
-	}
-	go s.scaleReadLoop(fd)
+// Creates a Scale reading from an arbitrary ScaleSource.
+func NewScaleFromSource(name string, src ScaleSource, trig <- chan time.Time) (*Scale, error) {
+	s := newScale(name, trig)
+	return s, s.start(src)
+}
 
-	// Begin triggering.
-	triggerfd, err := os.OpenFile(s.Trigger + "/trigger_now", os.O_WRONLY | os.O_SYNC, 0)
-	if err != nil {
-		return s, err
-	}
+func newScale(dev string, trig <- chan time.Time) *Scale {
+	s := new(Scale)
+	s.TriggerC = trig
+	s.previousRead = 0
+	s.EmitterID = s
+	s.Device = dev
+	s.Recording = false
+	s.Unit = Grams
 
-	// Every tick write to the trigger_now file.
-	go s.tickerTrigger(triggerfd)
+	// Single load cell stands only use channel 0.
+	s.Channels[0] = newScaleChannel(true)
+	s.Channels[1] = newScaleChannel(false)
+
+	// Pick up where the last session left off. Tare is still needed, but the slope carries over.
+	s.loadCalibration()
+
+	return s
+}
+
+func (s *Scale) start(src ScaleSource) error {
+	s.source = src
+
+	s.samples.SetCapacity(80 * 60) // 80 samples / second & average test length
+
+	go s.scaleReadLoop(src)
+
+	// Every tick, trigger a sample.
+	go s.tickerTrigger()
 
 	// Every 250ms emit a value of the current rolling average
-	s.readTic = *time.NewTicker(250 * time.Millisecond)
+	s.readTic = time.NewTicker(250 * time.Millisecond)
 	go s.tickerRead()
 
 	// Ready for Tare.
+	s.settings.Lock()
 	s.Initialized = true
+	s.settings.Unlock()
 
-	return s, err
+	return nil
 }
 
 func (s *Scale) Close() {
-	// TODO: Implement some clean-up. :-)
-	return
+	if s.source == nil {
+		return
+	}
+
+	s.settings.Lock()
+	s.Initialized = false
+	s.settings.Unlock()
+	s.readTic.Stop()
+	s.source.Close()
+}
+
+// True while the scale is reading samples.
+func (s *Scale) IsInitialized() bool {
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	return s.Initialized
+}
+
+// True once every enabled channel is calibrated.
+func (s *Scale) IsCalibrated() bool {
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	return s.Calibrated
 }
 
 func (s *Scale) eventName() string {
 	return "Scale"
 }
 
-func (s *Scale) tickerTrigger(triggerfd *os.File) {
-	for range s.TriggerC {
-		triggerfd.Write([]byte("1"))
+func (s *Scale) tickerTrigger() {
+	for t := range s.TriggerC {
+		if err := s.source.Trigger(t); sourceClosed(err) {
+			return
+		}
 	}
 }
 
@@ -213,10 +326,20 @@ func (s *Scale) StartRecording() {
 	s.Lock()
 	defer s.Unlock()
 
-	s.Recording = false
 	s.recordedSamples = nil
 	s.recordedSamples = make([]Sample, 0)
-	s.Recording = true
+	s.recordedFilter = s.Filter()
+	s.setRecording(true)
+
+	// Seed the recording from the ring. Samples read from here on are marked Recording, and recorded as they arrive.
+	if s.preTrigger > 0 {
+		since := time.Now().Add(-s.preTrigger).UnixNano()
+		for _, v := range s.samples.Values() {
+			if sample := v.(Sample); sample.Timestamp >= since && !sample.Recording {
+				s.recordedSamples = append(s.recordedSamples, sample)
+			}
+		}
+	}
 
 	s.Emit(s)
 }
@@ -224,7 +347,7 @@ func (s *Scale) StartRecording() {
 func (s *Scale) StopRecording() {
 	s.Lock()
 	defer s.Unlock()
-	s.Recording = false
+	s.setRecording(false)
 
 	s.Emit(s)
 }
@@ -233,16 +356,35 @@ func (s *Scale) ResetRecording() {
 	s.Lock()
 	defer s.Unlock()
 
-	s.Recording = false
+	s.setRecording(false)
 	s.recordedSamples = nil
 	s.recordedSamples = make([]Sample, 0)
 }
 
+// Sets how far back recordings reach into the samples read before StartRecording.
+func (s *Scale) SetPreTrigger(window time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.preTrigger = clampPreTrigger(window)
+}
+
+func (s *Scale) setRecording(recording bool) {
+	s.settings.Lock()
+	defer s.settings.Unlock()
+
+	s.Recording = recording
+}
+
 func (s *Scale) GetRecordedData() map[*zip.FileHeader][]byte {
 	s.Lock()
 	defer s.Unlock()
 
 	files := make(map[*zip.FileHeader][]byte)
+	if len(s.recordedSamples) == 0 {
+		return files
+	}
+
 	header := &zip.FileHeader {
 		Name:   "scale.json",
 		Modified: time.Unix(0, s.recordedSamples[0].Timestamp),
@@ -250,35 +392,73 @@ func (s *Scale) GetRecordedData() map[*zip.FileHeader][]byte {
 	}
 
 	files[header], _ = json.Marshal(s.recordedSamples)
+
+	// The calibration the samples were converted with.
+	if s.Calibrated {
+		if calibration, err := jsonArchiveFile("calibration.json", s.calibration()); err == nil {
+			for header, data := range calibration {
+				files[header] = data
+			}
+		}
+	}
+
+	if len(s.recordedFilter.Stages) > 0 {
+		if filter, err := s.recordedFilter.ArchiveFile(); err == nil {
+			for header, data := range filter {
+				files[header] = data
+			}
+		}
+	}
 	return files
 }
 
+// A copy of the samples recorded so far.
+func (s *Scale) RecordedSamples() []Sample {
+	s.Lock()
+	defer s.Unlock()
+
+	samples := make([]Sample, len(s.recordedSamples))
+	copy(samples, s.recordedSamples)
+	return samples
+}
+
 func (s *Scale) tickerRead() {
 	for range s.readTic.C {
 		s.Read()
 	}
 }
 
-func (s *Scale) scaleReadLoop(dev *os.File) {
-	samp := make([]byte, 16) // Single sample
+func (s *Scale) scaleReadLoop(dev ScaleSource) {
+	samp := make([]byte, ScaleSampleSize) // Single sample
 	for {
-		n, _ := dev.Read(samp)
-		if n == 16 {
-			p := Sample {
-				Initialized: s.Initialized,
-				Calibrated: s.Calibrated,
-				Recording: s.Recording,
-				ZeroOffset: s.ZeroOffset,
-				Adjust: s.Adjust,
-
-				Timestamp: tsConvert(samp[8:16]),
-				Volt0: binary.LittleEndian.Uint32(samp[0:4]),
-				Volt1: binary.LittleEndian.Uint32(samp[4:8]),
+		n, err := dev.Read(samp)
+		if err == io.EOF || sourceClosed(err) {
+			return
+		}
+		if n == ScaleSampleSize {
+			s.settings.RLock()
+			p := s.sample()
+			weights := s.weights()
+			pipeline := s.pipeline
+			s.settings.RUnlock()
+			p.Timestamp = tsConvert(samp[8:16])
+			p.Volt0 = binary.LittleEndian.Uint32(samp[0:4])
+			p.Volt1 = binary.LittleEndian.Uint32(samp[4:8])
+			if pipeline != nil {
+				// Only this loop runs the pipeline, so it needs no lock of its own.
+				if weights[0] != 0 {
+					v0 := pipeline.apply(0, float64(p.Volt0), p.Timestamp)
+					p.Volt0Filtered = &v0
+				}
+				if weights[1] != 0 {
+					v1 := pipeline.apply(1, float64(p.Volt1), p.Timestamp)
+					p.Volt1Filtered = &v1
+				}
 			}
-			p.CalculateMass()
+			p.CalculateMass(weights)
 			s.samples.Enqueue(p)
 
-			if s.Recording {
+			if p.Recording {
 				// Do this in the background so our Read() loop is _toight_
 				go func(scale *Scale, sample Sample) {
 					scale.Lock()
@@ -296,6 +476,34 @@ func (s *Scale) scaleReadLoop(dev *os.File) {
 	}
 }
 
+// A sample carrying the scale's state, ready for a reading. Must be called with the settings lock held.
+func (s *Scale) sample() Sample {
+	p := Sample {
+		Initialized: s.Initialized,
+		Calibrated: s.Calibrated,
+		Recording: s.Recording,
+		Unit: s.Unit,
+	}
+	if c := s.Channels[0]; c.Enabled {
+		p.ZeroOffset, p.Adjust, p.Quadratic = c.ZeroOffset, c.Adjust, c.Quadratic
+	}
+	if c := s.Channels[1]; c.Enabled && c.Calibrated {
+		p.Volt1ZeroOffset, p.Volt1Adjust, p.Volt1Quadratic = c.ZeroOffset, c.Adjust, c.Quadratic
+	}
+	return p
+}
+
+// Weights of the enabled channels in the combined mass. Must be called with the settings lock held.
+func (s *Scale) weights() [ScaleChannels]float64 {
+	var weights [ScaleChannels]float64
+	for ch, c := range s.Channels {
+		if c.Enabled {
+			weights[ch] = c.Weight
+		}
+	}
+	return weights
+}
+
 func deviceEcho(filename string, data []byte, perm os.FileMode) error {
 	f, err := os.OpenFile(filename, os.O_WRONLY, perm)
 	defer f.Close()
@@ -312,47 +520,44 @@ func deviceEcho(filename string, data []byte, perm os.FileMode) error {
 	return err
 }
 
+// Tares every enabled channel.
 func (s *Scale) Tare() {
 	s.Lock()
 	defer s.Unlock()
 
-	// Reset the ring buffer.
-	s.samples.SetCapacity(s.samples.Capacity())
-	// Get a rolling average for the Tare reading.
-	s.ZeroOffset = int(s.RollingAverage(1 * time.Millisecond).Volt0)
-
-	// Always set the first known weight to the scale's tare
-	s.Measured[0] = s.ZeroOffset
+	channels := make([]int, 0, ScaleChannels)
+	for ch, c := range s.Channels {
+		if c.Enabled {
+			channels = append(channels, ch)
+		}
+	}
+	s.tare(channels...)
 }
 
+// Calibrates channel 0 with a known mass.
 func (s *Scale) Calibrate(mass int) error {
+	return s.CalibrateChannel(0, mass)
+}
+
+// Sets the unit calibration masses are in. Known masses can't be reinterpreted, so the unit can only change
+// while the scale has none.
+func (s *Scale) SetUnit(unit MassUnit) error {
 	s.Lock()
 	defer s.Unlock()
 
-	// Make sure we're Tared.
-	if len(s.Measured) < 1 {
-		return errors.New("scale has not been tared")
-	}
-	// Reset the ring buffer.
-	s.samples.SetCapacity(s.samples.Capacity())
-	// Get a rolling average for the mass reading.
-	s.Measured[mass] = int(s.RollingAverage(750 * time.Millisecond).Volt0)
-
-	// Compute the adjust values for each mass.
-	var accumulated float64 = 0
-	discount := 0
-	for mass, measured := range s.Measured {
-		if mass == 0 {
-			discount++
-			continue
+	if unit == s.Unit {
+		return nil
+	}
+	for _, c := range s.Channels {
+		if len(c.Measured) > 1 {
+			return fmt.Errorf("scale is calibrated in %s, remove the calibration points before changing units", s.Unit)
 		}
-		accumulated += float64(measured-s.ZeroOffset) / float64(mass)
 	}
-	s.Adjust = accumulated / float64(len(s.Measured) - discount) // Ignore values that would cause division by zero.
+	s.settings.Lock()
+	s.Unit = unit
+	s.settings.Unlock()
 
-	s.Calibrated = len(s.Measured) > 1
-
-	return nil
+	return s.calibration().Save()
 }
 
 func (s *Scale) RollingAverage(duration time.Duration) Sample {
@@ -360,6 +565,7 @@ func (s *Scale) RollingAverage(duration time.Duration) Sample {
 	var volt0mass float64 = 0
 	var volt1sum uint32 = 0
 	var volt1mass float64 = 0
+	var totalmass float64 = 0
 
 	start := time.Now().Add(-1 * duration).UnixNano()
 	var count uint32 = 0
@@ -379,26 +585,25 @@ func (s *Scale) RollingAverage(duration time.Duration) Sample {
 					if sample.(Sample).Volt1Mass != nil {
 						volt1mass += *sample.(Sample).Volt1Mass
 					}
+					if sample.(Sample).Mass != nil {
+						totalmass += *sample.(Sample).Mass
+					}
 				}
 				count++
 			}
 		}
 	}
 
-	samp := Sample {
-		// Scale state
-		Initialized: s.Initialized,
-		Calibrated: s.Calibrated,
-		ZeroOffset: s.ZeroOffset,
-		Adjust: s.Adjust,
+	s.settings.RLock()
+	// Scale state
+	samp := s.sample()
+	samp.Recording = false
+	s.settings.RUnlock()
 
-		// Measured Data
-		Timestamp: start,
-		Volt0: volt0sum / count,
-		Volt0Mass: nil,
-		Volt1: volt1sum / count,
-		Volt1Mass: nil,
-	}
+	// Measured Data
+	samp.Timestamp = start
+	samp.Volt0 = volt0sum / count
+	samp.Volt1 = volt1sum / count
 	if volt0mass > 0 {
 		v0m := volt0mass / masscount
 		samp.Volt0Mass = &v0m
@@ -407,10 +612,49 @@ func (s *Scale) RollingAverage(duration time.Duration) Sample {
 		v1m := volt1mass / masscount
 		samp.Volt1Mass = &v1m
 	}
+	if totalmass > 0 {
+		m := totalmass / masscount
+		samp.Mass = &m
+	}
+	samp.calculateThrust()
 
 	return samp
 }
 
+// Returns the largest combined thrust, in newtons, of the samples stamped after `after` (unix nanoseconds), and the
+// newest of their timestamps, or after if there are none. ok is false if there are no calibrated samples in that time.
+func (s *Scale) PeakThrust(after int64) (peak float64, latest int64, ok bool) {
+	latest = after
+	for _, sample := range s.samples.Values() {
+		sample := sample.(Sample)
+		if sample.Timestamp <= after {
+			continue
+		}
+		if sample.Timestamp > latest {
+			latest = sample.Timestamp
+		}
+		if sample.Newtons != nil {
+			if !ok || *sample.Newtons > peak {
+				peak = *sample.Newtons
+			}
+			ok = true
+		}
+	}
+	return peak, latest, ok
+}
+
+// Timestamp of the newest sample read, 0 before the first. Samples are stamped by the source, so this, rather than
+// a time from another clock, is what to give PeakThrust.
+func (s *Scale) LastSample() int64 {
+	var last int64
+	for _, sample := range s.samples.Values() {
+		if ts := sample.(Sample).Timestamp; ts > last {
+			last = ts
+		}
+	}
+	return last
+}
+
 func (s *Scale) Read() Sample {
 	start := s.previousRead
 	if start == 0 {
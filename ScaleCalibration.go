@@ -0,0 +1,224 @@
+package pi_launch_control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Where scale calibrations are saved, one file per device. Empty disables saving and loading them.
+var ScaleCalibrationDir = "/var/lib/pi-launch-control/calibration"
+
+// A Scale's calibration, as saved between restarts.
+//
+// swagger:model
+type ScaleCalibration struct {
+	Device			string
+	// Unit of the Measured masses. Empty is taken as grams.
+	Unit			MassUnit
+	Channels		[]ScaleChannel
+}
+
+func (c ScaleCalibration) Validate() error {
+	if _, err := ParseMassUnit(string(c.Unit)); err != nil {
+		return err
+	}
+	if len(c.Channels) == 0 || len(c.Channels) > ScaleChannels {
+		return fmt.Errorf("Channels must have between 1 and %d entries", ScaleChannels)
+	}
+	enabled := false
+	for ch, channel := range c.Channels {
+		if err := channel.Validate(); err != nil {
+			return fmt.Errorf("channel %d: %v", ch, err)
+		}
+		enabled = enabled || channel.Enabled
+	}
+	if !enabled {
+		return errors.New("at least one channel must be enabled")
+	}
+	return nil
+}
+
+// Where the calibration for a device is saved.
+func scaleCalibrationPath(device string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, device)
+	return filepath.Join(ScaleCalibrationDir, strings.Trim(name, "_.") + ".json")
+}
+
+// Loads the saved calibration for a device. The error satisfies os.IsNotExist if there isn't one.
+func LoadScaleCalibration(device string) (ScaleCalibration, error) {
+	var c ScaleCalibration
+	if ScaleCalibrationDir == "" {
+		return c, os.ErrNotExist
+	}
+
+	b, err := ioutil.ReadFile(scaleCalibrationPath(device))
+	if err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	c.Device = device
+
+	// Calibrations saved before scales had channels are for channel 0.
+	if len(c.Channels) == 0 {
+		channel := newScaleChannel(true)
+		if err = json.Unmarshal(b, &channel); err != nil {
+			return c, err
+		}
+		channel.Enabled, channel.Weight = true, 1
+		c.Channels = []ScaleChannel{ channel }
+	}
+	return c, c.Validate()
+}
+
+// Saves the calibration, replacing any saved for the same device.
+func (c ScaleCalibration) Save() error {
+	if ScaleCalibrationDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(ScaleCalibrationDir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	path := scaleCalibrationPath(c.Device)
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Removes the saved calibration for a device, if there is one.
+func ClearScaleCalibration(device string) error {
+	if ScaleCalibrationDir == "" {
+		return nil
+	}
+	if err := os.Remove(scaleCalibrationPath(device)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// A copy of the scale's current calibration.
+func (s *Scale) Calibration() ScaleCalibration {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.calibration()
+}
+
+func (s *Scale) calibration() ScaleCalibration {
+	c := ScaleCalibration {
+		Device: 		s.Device,
+		Unit: 			s.Unit,
+		Channels: 		make([]ScaleChannel, len(s.Channels)),
+	}
+	for ch, channel := range s.Channels {
+		c.Channels[ch] = channel.copy()
+	}
+	return c
+}
+
+// Replaces the scale's calibration, ie: with one exported from another session, and saves it.
+// The scale must be tared again before use.
+func (s *Scale) SetCalibration(c ScaleCalibration) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c.Device = s.Device
+	for ch := range c.Channels {
+		if c.Channels[ch].CalibratedAt == 0 && len(c.Channels[ch].Measured) > 1 {
+			c.Channels[ch].CalibratedAt = time.Now().UnixNano()
+		}
+	}
+	s.applyCalibration(c)
+
+	return s.calibration().Save()
+}
+
+// Forgets the scale's calibration, and any saved for its device.
+func (s *Scale) ClearCalibration() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.settings.Lock()
+	for ch := range s.Channels {
+		enabled, weight := s.Channels[ch].Enabled, s.Channels[ch].Weight
+		s.Channels[ch] = newScaleChannel(enabled)
+		s.Channels[ch].Weight = weight
+	}
+	s.updateCalibrated()
+	s.settings.Unlock()
+
+	return ClearScaleCalibration(s.Device)
+}
+
+// Loads the saved calibration for the scale's device, if there is one.
+func (s *Scale) loadCalibration() {
+	c, err := LoadScaleCalibration(s.Device)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		fmt.Println("Unable to load scale calibration for", s.Device, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.applyCalibration(c)
+}
+
+// Refits the calibration's known masses, rather than trusting its Adjust. Every channel must be tared again.
+// Must be called with the scale's lock held.
+func (s *Scale) applyCalibration(c ScaleCalibration) {
+	s.settings.Lock()
+	s.Unit, _ = ParseMassUnit(string(c.Unit))
+	for ch := range s.Channels {
+		channel := newScaleChannel(false)
+		if ch < len(c.Channels) {
+			channel = c.Channels[ch].copy()
+		}
+		if channel.Weight == 0 {
+			channel.Weight = 1
+		}
+		if channel.FitOrder == 0 {
+			channel.FitOrder = 1
+		}
+		if len(channel.Measured) == 0 {
+			channel.ZeroOffset = -1
+		}
+		channel.TaredAt = 0
+		s.Channels[ch] = channel
+	}
+	s.settings.Unlock()
+
+	for ch := range s.Channels {
+		if err := s.refit(ch); err != nil {
+			// Validated calibrations always fit linearly.
+			s.Channels[ch].FitOrder = 1
+			s.refit(ch)
+		}
+	}
+}
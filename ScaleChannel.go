@@ -0,0 +1,388 @@
+package pi_launch_control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Load cell channels a Scale reads, Volt0 and Volt1.
+const ScaleChannels = 2
+
+// Calibration, and state, of one load cell channel.
+//
+// swagger:model
+type ScaleChannel struct {
+	Enabled			bool
+	// Multiplies the channel's mass in the combined mass. 1 sums the channels. Zero is taken as 1.
+	Weight			float64
+	Calibrated		bool
+	// Zero Offset (tare) threshold
+	ZeroOffset		int
+	// Known measured values, by mass.
+	Measured		map[int]int
+	// The adjustment scale value, counts per mass unit.
+	Adjust			float64
+	// Counts per mass unit squared, for a second order calibration.
+	Quadratic		float64
+	// Order of the calibration fit, 1 (linear) or 2. Zero is taken as 1.
+	FitOrder		int
+	// The fit of the Measured values which Adjust and Quadratic come from.
+	Fit				*CalibrationFit
+	// Unix nanoseconds of the last calibration.
+	CalibratedAt	int64
+	// Unix nanoseconds of the last tare, zero until the channel is tared. Not restored with the calibration, since
+	// the zero drifts between sessions.
+	TaredAt			int64
+}
+
+func newScaleChannel(enabled bool) ScaleChannel {
+	return ScaleChannel {
+		Enabled: 	enabled,
+		Weight: 	1,
+		ZeroOffset: -1,
+		Measured: 	make(map[int]int),
+		FitOrder: 	1,
+	}
+}
+
+func (c ScaleChannel) Validate() error {
+	if len(c.Measured) > 0 {
+		if zero, ok := c.Measured[0]; !ok || zero != c.ZeroOffset {
+			return errors.New("Measured must include the ZeroOffset at mass 0")
+		}
+	}
+	for mass := range c.Measured {
+		if mass < 0 {
+			return errors.New("Measured masses must not be negative")
+		}
+	}
+	if c.FitOrder < 0 || c.FitOrder > 2 {
+		return errors.New("FitOrder must be 1 or 2")
+	}
+	if c.Weight < 0 || math.IsNaN(c.Weight) || math.IsInf(c.Weight, 0) {
+		return errors.New("Weight must be a positive number")
+	}
+	// The fit is redone when the calibration is applied, so the points must support one.
+	if len(c.Measured) > 1 {
+		if _, err := FitCalibration(c.Measured, c.ZeroOffset, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A copy which doesn't share Measured.
+func (c ScaleChannel) copy() ScaleChannel {
+	measured := make(map[int]int, len(c.Measured))
+	for mass, m := range c.Measured {
+		measured[mass] = m
+	}
+	c.Measured = measured
+	return c
+}
+
+// Raw counts for a channel.
+func (s Sample) counts(channel int) uint32 {
+	if channel == 1 {
+		return s.Volt1
+	}
+	return s.Volt0
+}
+
+func checkChannel(channel int) error {
+	if channel < 0 || channel >= ScaleChannels {
+		return fmt.Errorf("scale channel must be between 0 and %d", ScaleChannels - 1)
+	}
+	return nil
+}
+
+// Channels to enable on the scale's source.
+func (s *Scale) enabledChannels() []int {
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	enabled := make([]int, 0, ScaleChannels)
+	for ch := range s.Channels {
+		if s.Channels[ch].Enabled {
+			enabled = append(enabled, ch)
+		}
+	}
+	return enabled
+}
+
+// True if every enabled channel has been tared this session.
+func (s *Scale) Tared() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, c := range s.Channels {
+		if c.Enabled && c.TaredAt == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Unix nanoseconds of the oldest calibration of an enabled channel.
+func (s *Scale) CalibratedAt() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	var oldest int64
+	for _, c := range s.Channels {
+		if c.Enabled && (oldest == 0 || c.CalibratedAt < oldest) {
+			oldest = c.CalibratedAt
+		}
+	}
+	return oldest
+}
+
+// Enables or disables a channel, and sets its weight in the combined mass. Sources only enable channels when
+// they're opened, so the scale needs recreating after enabling a channel on an IIO device.
+func (s *Scale) ConfigureChannel(channel int, enabled bool, weight float64) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+	if weight <= 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return errors.New("weight must be a positive number")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !enabled {
+		others := false
+		for ch, c := range s.Channels {
+			others = others || (ch != channel && c.Enabled)
+		}
+		if !others {
+			return errors.New("at least one scale channel must be enabled")
+		}
+	}
+
+	s.settings.Lock()
+	s.Channels[channel].Enabled = enabled
+	s.Channels[channel].Weight = weight
+	s.updateCalibrated()
+	s.settings.Unlock()
+
+	return s.calibration().Save()
+}
+
+// Tares a single channel.
+func (s *Scale) TareChannel(channel int) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.Channels[channel].Enabled {
+		return fmt.Errorf("scale channel %d is not enabled", channel)
+	}
+	s.tare(channel)
+	return nil
+}
+
+// Tares the given channels. Must be called with the scale's lock held.
+func (s *Scale) tare(channels ...int) {
+	// Reset the ring buffer.
+	s.samples.SetCapacity(s.samples.Capacity())
+	// Get a rolling average for the Tare reading.
+	average := s.RollingAverage(1 * time.Millisecond)
+
+	now := time.Now().UnixNano()
+	for _, ch := range channels {
+		s.setZero(ch, int(average.counts(ch)), now)
+	}
+
+	if s.Calibrated {
+		if err := s.calibration().Save(); err != nil {
+			fmt.Println("Unable to save scale calibration.", err)
+		}
+	}
+}
+
+// Carries each channel's tare over from a scale on the same device, ie: when it's reopened. Channels with no tare to
+// carry over, such as one just enabled, are left with TaredAt zero.
+func (s *Scale) RestoreTares(previous ScaleCalibration) {
+	s.Lock()
+	defer s.Unlock()
+
+	for ch := range s.Channels {
+		if !s.Channels[ch].Enabled || ch >= len(previous.Channels) || previous.Channels[ch].TaredAt == 0 {
+			continue
+		}
+		s.setZero(ch, previous.Channels[ch].ZeroOffset, previous.Channels[ch].TaredAt)
+	}
+
+	if s.Calibrated {
+		if err := s.calibration().Save(); err != nil {
+			fmt.Println("Unable to save scale calibration.", err)
+		}
+	}
+}
+
+// Sets a channel's zero, tared at the given unix nanoseconds. Must be called with the scale's lock held.
+func (s *Scale) setZero(channel int, zero int, taredAt int64) {
+	c := &s.Channels[channel]
+
+	s.settings.Lock()
+	previous := c.ZeroOffset
+	c.ZeroOffset = zero
+	s.settings.Unlock()
+
+	// Shift the known weights to the new zero, keeping the calibrated slope.
+	if previous != -1 {
+		for mass, measured := range c.Measured {
+			c.Measured[mass] = measured + zero - previous
+		}
+	}
+	// Always set the first known weight to the channel's tare
+	c.Measured[0] = zero
+	c.TaredAt = taredAt
+}
+
+// Records the reading for a known mass on a single channel, and refits its calibration.
+func (s *Scale) CalibrateChannel(channel int, mass int) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c := &s.Channels[channel]
+	if !c.Enabled {
+		return fmt.Errorf("scale channel %d is not enabled", channel)
+	}
+	// Make sure we're Tared this session.
+	if c.TaredAt == 0 {
+		return fmt.Errorf("scale channel %d has not been tared", channel)
+	}
+	if mass <= 0 {
+		return errors.New("calibration mass must be positive")
+	}
+	// Reset the ring buffer.
+	s.samples.SetCapacity(s.samples.Capacity())
+	// Get a rolling average for the mass reading.
+	previous, replacing := c.Measured[mass]
+	c.Measured[mass] = int(s.RollingAverage(750 * time.Millisecond).counts(channel))
+
+	// Fit all the known masses, putting things back if the new point can't be fit.
+	if err := s.refit(channel); err != nil {
+		if replacing {
+			c.Measured[mass] = previous
+		} else {
+			delete(c.Measured, mass)
+		}
+		s.refit(channel)
+		return err
+	}
+	c.CalibratedAt = time.Now().UnixNano()
+
+	return s.calibration().Save()
+}
+
+// Removes a known mass from a channel's calibration, and refits the rest.
+func (s *Scale) RemoveCalibrationPoint(channel int, mass int) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c := &s.Channels[channel]
+	if mass == 0 {
+		return errors.New("the tare reading can't be removed, tare the scale instead")
+	}
+	previous, ok := c.Measured[mass]
+	if !ok {
+		return fmt.Errorf("no calibration point for mass %d on channel %d", mass, channel)
+	}
+
+	delete(c.Measured, mass)
+	if err := s.refit(channel); err != nil {
+		c.Measured[mass] = previous
+		s.refit(channel)
+		return err
+	}
+	c.CalibratedAt = time.Now().UnixNano()
+
+	return s.calibration().Save()
+}
+
+// Sets the order of a channel's calibration fit, 1 (linear) or 2, and refits the known masses.
+func (s *Scale) SetFitOrder(channel int, order int) error {
+	if err := checkChannel(channel); err != nil {
+		return err
+	}
+	if order != 1 && order != 2 {
+		return errors.New("calibration fit order must be 1 or 2")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c := &s.Channels[channel]
+	previous := c.FitOrder
+	c.FitOrder = order
+	if err := s.refit(channel); err != nil {
+		c.FitOrder = previous
+		s.refit(channel)
+		return err
+	}
+
+	return s.calibration().Save()
+}
+
+// Fits a channel's known masses, updating its conversion to mass. Orders higher than the points support are
+// reduced. Must be called with the scale's lock held.
+func (s *Scale) refit(channel int) error {
+	c := &s.Channels[channel]
+	order := c.FitOrder
+	if order > len(c.Measured) - 1 {
+		order = len(c.Measured) - 1
+	}
+
+	var fit *CalibrationFit
+	if order >= 1 {
+		f, err := FitCalibration(c.Measured, c.ZeroOffset, order)
+		if err != nil {
+			return err
+		}
+		fit = &f
+	}
+
+	s.settings.Lock()
+	if fit != nil {
+		c.Adjust, c.Quadratic = fit.Slope, fit.Quadratic
+	} else {
+		c.Adjust, c.Quadratic = 0, 0
+	}
+	c.Calibrated = fit != nil
+	s.updateCalibrated()
+	s.settings.Unlock()
+	c.Fit = fit
+
+	return nil
+}
+
+// The scale is calibrated once every enabled channel is. Must be called with the settings lock held.
+func (s *Scale) updateCalibrated() {
+	calibrated := false
+	for _, c := range s.Channels {
+		if c.Enabled {
+			if !c.Calibrated {
+				calibrated = false
+				break
+			}
+			calibrated = true
+		}
+	}
+	s.Calibrated = calibrated
+}
@@ -0,0 +1,204 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Kinds of scale filter stage.
+type ScaleFilterKind string
+
+const (
+	// Mean of the last Window samples.
+	FilterAverage	ScaleFilterKind = "average"
+	// Median of the last Window samples.
+	FilterMedian	ScaleFilterKind = "median"
+	// Single-pole IIR low-pass with a Cutoff frequency.
+	FilterLowPass	ScaleFilterKind = "lowpass"
+	// Replaces samples further than Threshold counts from the median of the last Window samples with that median.
+	FilterSpike		ScaleFilterKind = "spike"
+)
+
+// Longest window a stage may use, a few seconds of samples.
+const maxFilterWindow = 400
+
+// Sample period assumed when timestamps don't give one, 80 samples / second.
+const defaultSamplePeriod = 1.0 / 80
+
+// One stage of a scale filter.
+//
+// swagger:model
+type ScaleFilterStage struct {
+	Kind			ScaleFilterKind
+	// Samples in the window, for average, median and spike.
+	Window			int `json:",omitempty"`
+	// Corner frequency in Hz, for lowpass.
+	Cutoff			float64 `json:",omitempty"`
+	// Counts from the median beyond which a sample is a spike, for spike.
+	Threshold		float64 `json:",omitempty"`
+}
+
+// Filters applied, in order, to each channel's raw counts before they're converted to mass.
+//
+// swagger:model
+type ScaleFilter struct {
+	Stages			[]ScaleFilterStage
+}
+
+func (f ScaleFilter) Validate() error {
+	for idx, stage := range f.Stages {
+		if err := stage.Validate(); err != nil {
+			return fmt.Errorf("stage %d: %v", idx, err)
+		}
+	}
+	return nil
+}
+
+func (s ScaleFilterStage) Validate() error {
+	switch s.Kind {
+	case FilterAverage, FilterMedian, FilterSpike:
+		if s.Window < 1 || s.Window > maxFilterWindow {
+			return fmt.Errorf("Window must be between 1 and %d samples", maxFilterWindow)
+		}
+		if s.Kind == FilterSpike && (s.Threshold <= 0 || math.IsNaN(s.Threshold) || math.IsInf(s.Threshold, 0)) {
+			return errors.New("Threshold must be a positive number of counts")
+		}
+	case FilterLowPass:
+		if s.Cutoff <= 0 || math.IsNaN(s.Cutoff) || math.IsInf(s.Cutoff, 0) {
+			return errors.New("Cutoff must be a positive frequency")
+		}
+	default:
+		return fmt.Errorf("unknown filter %q, use average, median, lowpass or spike", s.Kind)
+	}
+	return nil
+}
+
+// The filter as a filter.json archive entry.
+func (f ScaleFilter) ArchiveFile() (map[*zip.FileHeader][]byte, error) {
+	return jsonArchiveFile("filter.json", f)
+}
+
+// The running state of a ScaleFilter for every channel. Only the scale's read loop uses it.
+type filterPipeline struct {
+	channels		[ScaleChannels][]filterState
+}
+
+type filterState struct {
+	ScaleFilterStage
+	history			[]float64
+	output			float64
+	previous		int64
+	started			bool
+}
+
+func newFilterPipeline(f ScaleFilter) *filterPipeline {
+	if len(f.Stages) == 0 {
+		return nil
+	}
+
+	p := new(filterPipeline)
+	for ch := range p.channels {
+		p.channels[ch] = make([]filterState, len(f.Stages))
+		for idx, stage := range f.Stages {
+			p.channels[ch][idx].ScaleFilterStage = stage
+		}
+	}
+	return p
+}
+
+// Runs a raw reading on a channel through every stage.
+func (p *filterPipeline) apply(channel int, counts float64, timestamp int64) float64 {
+	for idx := range p.channels[channel] {
+		counts = p.channels[channel][idx].apply(counts, timestamp)
+	}
+	return counts
+}
+
+func (f *filterState) apply(x float64, timestamp int64) float64 {
+	switch f.Kind {
+	case FilterAverage:
+		f.remember(x)
+		sum := 0.0
+		for _, h := range f.history {
+			sum += h
+		}
+		return sum / float64(len(f.history))
+	case FilterMedian:
+		f.remember(x)
+		return median(f.history)
+	case FilterSpike:
+		// Judge the sample against the samples before it, so a spike can't pull the median towards itself.
+		if len(f.history) > 0 {
+			m := median(f.history)
+			f.remember(x)
+			if math.Abs(x - m) > f.Threshold {
+				return m
+			}
+			return x
+		}
+		f.remember(x)
+		return x
+	case FilterLowPass:
+		if !f.started {
+			f.output, f.previous, f.started = x, timestamp, true
+			return x
+		}
+		dt := float64(timestamp - f.previous) / 1e9
+		if dt <= 0 {
+			dt = defaultSamplePeriod
+		}
+		f.previous = timestamp
+		rc := 1 / (2 * math.Pi * f.Cutoff)
+		f.output += dt / (rc + dt) * (x - f.output)
+		return f.output
+	}
+	return x
+}
+
+func (f *filterState) remember(x float64) {
+	f.history = append(f.history, x)
+	if len(f.history) > f.Window {
+		f.history = f.history[1:]
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted) % 2 == 0 {
+		return (sorted[mid - 1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// The scale's filter.
+func (s *Scale) Filter() ScaleFilter {
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	return s.filter
+}
+
+// Replaces the scale's filter, starting it afresh. An empty filter turns filtering off.
+func (s *Scale) SetFilter(f ScaleFilter) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	stages := make([]ScaleFilterStage, len(f.Stages))
+	copy(stages, f.Stages)
+	f.Stages = stages
+
+	s.settings.Lock()
+	defer s.settings.Unlock()
+
+	s.filter = f
+	s.pipeline = newFilterPipeline(f)
+	return nil
+}
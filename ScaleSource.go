@@ -0,0 +1,230 @@
+package pi_launch_control
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size in bytes of a single scale sample as read from a ScaleSource.
+//
+// Layout (little endian):
+//   [0:4]  Volt0
+//   [4:8]  Volt1
+//   [8:16] Timestamp (unix nanoseconds)
+const ScaleSampleSize = 16
+
+// A ScaleSource supplies raw samples to a Scale.
+//
+// Every Read() returns a single ScaleSampleSize sample in the IIO buffer layout, blocking until one is available.
+// Trigger() requests that a new sample be taken.
+type ScaleSource interface {
+	Read(p []byte) (int, error)
+	Trigger(t time.Time) error
+	Close() error
+}
+
+// Default locations of sysfs and devfs.
+const (
+	DefaultSysfsRoot = "/sys"
+	DefaultDevfsRoot = "/dev"
+)
+
+// ScaleSource backed by an IIO device with a sysfs trigger.
+type IIOScaleSource struct {
+	// Where sysfs and devfs are mounted. Absolute /sys and /dev paths are resolved relative to these.
+	SysfsRoot		string
+	DevfsRoot		string
+
+	iIODevice  		string
+	devDevice  		string
+	idxTime    		int
+	idxVoltage 		int
+	// Channel 1 is read without channel 0, so arrives where channel 0 would.
+	shiftVolt1		bool
+
+	dev				*os.File
+	trigger			*os.File
+}
+
+// Creates an IIOScaleSource reading the given voltage channels, or only channel 0 if none are given.
+func NewIIOScaleSource(dev string, triggerDev string, channels ...int) (*IIOScaleSource, error) {
+	return NewIIOScaleSourceAt(DefaultSysfsRoot, DefaultDevfsRoot, dev, triggerDev, channels...)
+}
+
+// Creates an IIOScaleSource with sysfs and devfs mounted at the given roots.
+func NewIIOScaleSourceAt(sysfsRoot string, devfsRoot string, dev string, triggerDev string, channels ...int) (*IIOScaleSource, error) {
+	var err error = nil
+	if len(channels) == 0 {
+		channels = []int{ 0 }
+	}
+
+	src := &IIOScaleSource {
+		SysfsRoot: sysfsRoot,
+		DevfsRoot: devfsRoot,
+	}
+	dev = src.sysfs(dev)
+	triggerDev = src.sysfs(triggerDev)
+	sysfsTrigger := src.sysfs("/sys/bus/iio/devices/iio_sysfs_trigger")
+
+	files, err := ioutil.ReadDir(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "iio:device") {
+			src.iIODevice = filepath.Join(dev, f.Name())
+			src.devDevice = src.devfs(f.Name())
+			break
+		}
+	}
+	if src.iIODevice == "" {
+		return nil, fmt.Errorf("no iio:device found in %s", dev)
+	}
+
+	// If the sysfs trigger doesn't exist, then we try to create one.
+	if _, err := os.Stat(triggerDev); err != nil {
+
+		// Make sure we have the proper sysfs bits.
+		if _, err := os.Stat(sysfsTrigger); err != nil {
+			fmt.Println("Sysfs Triggering Unavilable.", err)
+			return nil, err
+		}
+
+		// Create trigger0 if it doesn't exist.
+		if _, err := os.Stat(sysfsTrigger + "/trigger0"); err != nil {
+			// Create trigger0 since it does not exist
+			if err := deviceEcho(sysfsTrigger + "/add_trigger", []byte("0"), 0200); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// By the time we get here we know we have sysfstrigger0
+	triggerName, err := ioutil.ReadFile(triggerDev + "/name")
+	if err != nil {
+		return nil, err
+	}
+
+	// Disable the buffer and Set the trigger as the iio:device trigger.
+	err = deviceEcho(src.iIODevice + "/buffer/enable", []byte("0"), 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := deviceEcho(src.iIODevice + "/trigger/current_trigger", triggerName, 0); err != nil {
+		return nil, err
+	}
+
+	// Get the timestamp and the voltages asked for, turning off any others.
+	deviceEcho(src.iIODevice + "/scan_elements/in_timestamp_en", []byte("1"), 0644)
+	for ch := 0; ch < ScaleChannels; ch++ {
+		enable := []byte("0")
+		for _, c := range channels {
+			if c == ch {
+				enable = []byte("1")
+			}
+		}
+		deviceEcho(src.iIODevice + fmt.Sprintf("/scan_elements/in_voltage%d_en", ch), enable, 0644)
+	}
+
+	// Find out what index the items are.
+	src.idxTime, err = readSysfsInt(src.iIODevice + "/scan_elements/in_timestamp_index")
+	if err != nil {
+		return nil, err
+	}
+	src.idxVoltage, err = readSysfsInt(src.iIODevice + fmt.Sprintf("/scan_elements/in_voltage%d_index", channels[0]))
+	if err != nil {
+		return nil, err
+	}
+	src.shiftVolt1 = len(channels) == 1 && channels[0] == 1
+
+	// Go ahead and start reading....
+	err = deviceEcho(src.iIODevice + "/buffer/enable", []byte("1"), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the buffer actually took.
+	enabled, err := readSysfsInt(src.iIODevice + "/buffer/enable")
+	if err != nil {
+		return nil, err
+	}
+	if enabled != 1 {
+		return nil, fmt.Errorf("%s/buffer is not enabled", src.iIODevice)
+	}
+
+	// Attempt to open the device.
+	src.dev, err = os.Open(src.devDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the trigger.
+	src.trigger, err = os.OpenFile(triggerDev + "/trigger_now", os.O_WRONLY | os.O_SYNC, 0)
+	if err != nil {
+		src.dev.Close()
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// Resolves an absolute /sys path against SysfsRoot.
+func (src *IIOScaleSource) sysfs(path string) string {
+	return filepath.Join(src.SysfsRoot, strings.TrimPrefix(path, DefaultSysfsRoot))
+}
+
+// Resolves a device name against DevfsRoot.
+func (src *IIOScaleSource) devfs(name string) string {
+	return filepath.Join(src.DevfsRoot, name)
+}
+
+func (src *IIOScaleSource) Read(p []byte) (int, error) {
+	n, err := src.dev.Read(p)
+	if src.shiftVolt1 && n == ScaleSampleSize {
+		copy(p[4:8], p[0:4])
+		binary.LittleEndian.PutUint32(p[0:4], 0)
+	}
+	return n, err
+}
+
+func (src *IIOScaleSource) Trigger(t time.Time) error {
+	_, err := src.trigger.Write([]byte("1"))
+	return err
+}
+
+func (src *IIOScaleSource) Close() error {
+	// Stop the buffer so the device can be re-opened.
+	deviceEcho(src.iIODevice + "/buffer/enable", []byte("0"), 0)
+
+	err := src.trigger.Close()
+	if derr := src.dev.Close(); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// Reads a single integer value from a sysfs attribute.
+func readSysfsInt(filename string) (int, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", filename, err)
+	}
+	return v, nil
+}
+
+// Returns true if err indicates the source has been closed.
+func sourceClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed)
+}
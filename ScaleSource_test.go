@@ -0,0 +1,244 @@
+package pi_launch_control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	testScaleDevice		= "/sys/devices/platform/weight@0"
+	testScaleTrigger	= "/sys/bus/iio/devices/iio_sysfs_trigger/trigger0"
+)
+
+// A temporary IIO tree, laid out the way the kernel lays out an ADC with a sysfs trigger.
+type fakeIIO struct {
+	t			*testing.T
+	sysfs		string
+	devfs		string
+	// sysfs path of iio:device0
+	device		string
+	// sysfs path of the sysfs trigger, and of trigger0 within it.
+	sysTrigger	string
+	trigger		string
+}
+
+func newFakeIIO(t *testing.T) *fakeIIO {
+	root := t.TempDir()
+	f := &fakeIIO {
+		t: 			t,
+		sysfs: 		filepath.Join(root, "sys"),
+		devfs: 		filepath.Join(root, "dev"),
+	}
+	f.device = filepath.Join(f.sysfs, strings.TrimPrefix(testScaleDevice, DefaultSysfsRoot), "iio:device0")
+	f.sysTrigger = filepath.Join(f.sysfs, "bus/iio/devices/iio_sysfs_trigger")
+	f.trigger = filepath.Join(f.sysTrigger, "trigger0")
+
+	f.write(f.device, "buffer/enable", "0\n")
+	f.write(f.device, "trigger/current_trigger", "\n")
+	f.write(f.device, "scan_elements/in_timestamp_en", "0\n")
+	f.write(f.device, "scan_elements/in_timestamp_index", "2\n")
+	for ch := 0; ch < ScaleChannels; ch++ {
+		f.write(f.device, fmt.Sprintf("scan_elements/in_voltage%d_en", ch), "0\n")
+		f.write(f.device, fmt.Sprintf("scan_elements/in_voltage%d_index", ch), fmt.Sprintf("%d\n", ch))
+	}
+	f.mkfifo(f.devfs, "iio:device0")
+	f.addTrigger()
+	return f
+}
+
+// Writes a sysfs attribute, creating the directories it lives in.
+func (f *fakeIIO) write(dir string, name string, content string) {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeIIO) read(dir string, name string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+func (f *fakeIIO) mkfifo(dir string, name string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	return path
+}
+
+// Creates trigger0 as though the kernel had done it.
+func (f *fakeIIO) addTrigger() {
+	f.write(f.trigger, "name", "sysfstrig0\n")
+	f.write(f.trigger, "trigger_now", "")
+}
+
+func (f *fakeIIO) open(channels ...int) (*IIOScaleSource, error) {
+	return NewIIOScaleSourceAt(f.sysfs, f.devfs, testScaleDevice, testScaleTrigger, channels...)
+}
+
+func TestIIOScaleSourceRead(t *testing.T) {
+	f := newFakeIIO(t)
+
+	// The device can only be opened once something is writing samples.
+	sample := make([]byte, ScaleSampleSize)
+	binary.LittleEndian.PutUint32(sample[0:4], 1234)
+	binary.LittleEndian.PutUint64(sample[8:16], 42)
+	written := make(chan error, 1)
+	go func() {
+		written <- ioutil.WriteFile(filepath.Join(f.devfs, "iio:device0"), sample, 0)
+	}()
+
+	src, err := f.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.idxTime != 2 || src.idxVoltage != 0 {
+		t.Fatalf("indexes time %d voltage %d", src.idxTime, src.idxVoltage)
+	}
+	if trigger := f.read(f.device, "trigger/current_trigger"); trigger != "sysfstrig0" {
+		t.Fatalf("current_trigger %q", trigger)
+	}
+	if enabled := f.read(f.device, "scan_elements/in_voltage0_en"); enabled != "1" {
+		t.Fatalf("in_voltage0_en %q", enabled)
+	}
+	if enabled := f.read(f.device, "scan_elements/in_voltage1_en"); enabled != "0" {
+		t.Fatalf("in_voltage1_en %q", enabled)
+	}
+
+	buf := make([]byte, ScaleSampleSize)
+	if n, err := src.Read(buf); err != nil || n != ScaleSampleSize {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if volt0 := binary.LittleEndian.Uint32(buf[0:4]); volt0 != 1234 {
+		t.Fatalf("volt0 %d", volt0)
+	}
+	if ts := binary.LittleEndian.Uint64(buf[8:16]); ts != 42 {
+		t.Fatalf("timestamp %d", ts)
+	}
+
+	if err := src.Trigger(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if triggered := f.read(f.trigger, "trigger_now"); triggered != "1" {
+		t.Fatalf("trigger_now %q", triggered)
+	}
+
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if enabled := f.read(f.device, "buffer/enable"); enabled != "0" {
+		t.Fatalf("buffer left enabled after close: %q", enabled)
+	}
+}
+
+func TestIIOScaleSourceAddsTrigger(t *testing.T) {
+	f := newFakeIIO(t)
+	if err := os.RemoveAll(f.trigger); err != nil {
+		t.Fatal(err)
+	}
+	f.write(f.sysTrigger, "add_trigger", "")
+
+	// Nothing stands in for the kernel creating trigger0, so the open still fails once it's been asked for.
+	_, err := f.open()
+	if err == nil {
+		t.Fatal("opened without trigger0")
+	}
+	if !strings.Contains(err.Error(), "trigger0") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id := f.read(f.sysTrigger, "add_trigger"); id != "0" {
+		t.Fatalf("add_trigger got %q", id)
+	}
+}
+
+func TestIIOScaleSourceMissingTrigger(t *testing.T) {
+	f := newFakeIIO(t)
+	if err := os.RemoveAll(f.sysTrigger); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.open(); err == nil {
+		t.Fatal("opened without a sysfs trigger")
+	}
+}
+
+func TestIIOScaleSourceAddTriggerFails(t *testing.T) {
+	f := newFakeIIO(t)
+	if err := os.RemoveAll(f.trigger); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without add_trigger, trigger0 can't be created.
+	if _, err := f.open(); err == nil {
+		t.Fatal("opened without being able to add a trigger")
+	}
+}
+
+func TestIIOScaleSourceBadIndex(t *testing.T) {
+	f := newFakeIIO(t)
+	f.write(f.device, "scan_elements/in_voltage1_index", "one\n")
+
+	_, err := f.open(1)
+	if err == nil {
+		t.Fatal("opened with an unparsable index")
+	}
+	if !strings.Contains(err.Error(), "in_voltage1_index") {
+		t.Fatalf("error doesn't name the index file: %v", err)
+	}
+}
+
+func TestIIOScaleSourceBufferDisabled(t *testing.T) {
+	f := newFakeIIO(t)
+
+	// Stand in for a kernel which accepts the writes to buffer/enable, but leaves the buffer off.
+	enable := filepath.Join(f.device, "buffer/enable")
+	if err := os.Remove(enable); err != nil {
+		t.Fatal(err)
+	}
+	f.mkfifo(filepath.Dir(enable), "enable")
+	go func() {
+		// Disabled, then enabled. Reading on through EOF picks up each write without reopening, so none are missed.
+		kernel, err := os.Open(enable)
+		if err != nil {
+			return
+		}
+		written := make([]byte, 1)
+		for written[0] != '1' {
+			if n, _ := kernel.Read(written); n == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		kernel.Close()
+
+		// Then read back as disabled.
+		ioutil.WriteFile(enable, []byte("0\n"), 0)
+	}()
+
+	_, err := f.open()
+	if err == nil {
+		t.Fatal("opened with the buffer disabled")
+	}
+	if !strings.Contains(err.Error(), "not enabled") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package pi_launch_control
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Device name reported by a Camera built on a TestPatternFrameSource.
+const TestPatternCameraDevice = "testpattern"
+
+// FrameSource which loops over a set of JPEG files.
+type FileFrameSource struct {
+	sync.Mutex
+	frames			[][]byte
+	next			int
+}
+
+// Loads every .jpg in path, which may be a directory or a downloaded mission .zip.
+//
+// Frames are played in timestamp order when named like recorded frames (<timestamp>.jpg), otherwise by name.
+func NewFileFrameSource(path string) (*FileFrameSource, error) {
+	var err error = nil
+	src := new(FileFrameSource)
+
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		src.frames, err = zipFrames(path)
+	} else {
+		src.frames, err = dirFrames(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(src.frames) == 0 {
+		return nil, errors.New("no .jpg frames found in " + path)
+	}
+
+	return src, nil
+}
+
+func dirFrames(dir string) ([][]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() && isFrameName(f.Name()) {
+			names = append(names, f.Name())
+		}
+	}
+	sortFrameNames(names)
+
+	frames := make([][]byte, 0, len(names))
+	for _, name := range names {
+		frame, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+func zipFrames(path string) ([][]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	byName := make(map[string]*zip.File)
+	names := make([]string, 0)
+	for _, f := range zr.File {
+		if isFrameName(f.Name) {
+			byName[f.Name] = f
+			names = append(names, f.Name)
+		}
+	}
+	sortFrameNames(names)
+
+	frames := make([][]byte, 0, len(names))
+	for _, name := range names {
+		rc, err := byName[name].Open()
+		if err != nil {
+			return nil, err
+		}
+		frame, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+func isFrameName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".jpg") || strings.HasSuffix(lower, ".jpeg")
+}
+
+// Sorts numerically named frames by timestamp, and everything else by name.
+func sortFrameNames(names []string) {
+	sort.Slice(names, func(a, b int) bool {
+		ta, aerr := strconv.ParseInt(strings.TrimSuffix(filepath.Base(names[a]), filepath.Ext(names[a])), 10, 64)
+		tb, berr := strconv.ParseInt(strings.TrimSuffix(filepath.Base(names[b]), filepath.Ext(names[b])), 10, 64)
+		if aerr == nil && berr == nil {
+			return ta < tb
+		}
+		return names[a] < names[b]
+	})
+}
+
+func (src *FileFrameSource) GetFrame() ([]byte, error) {
+	src.Lock()
+	defer src.Unlock()
+
+	frame := make([]byte, len(src.frames[src.next]))
+	copy(frame, src.frames[src.next])
+
+	src.next = (src.next + 1) % len(src.frames)
+	return frame, nil
+}
+
+func (src *FileFrameSource) Close() error {
+	return nil
+}
+
+// FrameSource which generates color bars with a burned-in frame counter.
+type TestPatternFrameSource struct {
+	sync.Mutex
+	Width			int
+	Height			int
+	Quality			int
+
+	count			uint64
+}
+
+func NewTestPatternFrameSource(width int, height int) *TestPatternFrameSource {
+	return &TestPatternFrameSource {
+		Width: 		width,
+		Height: 	height,
+		Quality: 	75,
+	}
+}
+
+var testPatternBars = []color.RGBA {
+	{ 192, 192, 192, 255 },
+	{ 192, 192, 0, 255 },
+	{ 0, 192, 192, 255 },
+	{ 0, 192, 0, 255 },
+	{ 192, 0, 192, 255 },
+	{ 192, 0, 0, 255 },
+	{ 0, 0, 192, 255 },
+}
+
+// 3x5 bitmaps for 0-9, one row per entry, high bit on the left.
+var testPatternDigits = [10][5]uint8 {
+	{ 7, 5, 5, 5, 7 },
+	{ 2, 6, 2, 2, 7 },
+	{ 7, 1, 7, 4, 7 },
+	{ 7, 1, 7, 1, 7 },
+	{ 5, 5, 7, 1, 1 },
+	{ 7, 4, 7, 1, 7 },
+	{ 7, 4, 7, 5, 7 },
+	{ 7, 1, 1, 1, 1 },
+	{ 7, 5, 7, 5, 7 },
+	{ 7, 5, 7, 1, 7 },
+}
+
+func (src *TestPatternFrameSource) GetFrame() ([]byte, error) {
+	src.Lock()
+	n := src.count
+	src.count++
+	src.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, src.Width, src.Height))
+
+	// Color bars, with a sweep line so motion is obvious.
+	barWidth := src.Width / len(testPatternBars) + 1
+	sweep := int(n % uint64(src.Width))
+	for x := 0; x < src.Width; x++ {
+		c := testPatternBars[x / barWidth]
+		if x == sweep {
+			c = color.RGBA{ 255, 255, 255, 255 }
+		}
+		img.SetRGBA(x, 0, c)
+	}
+	for y := 1; y < src.Height; y++ {
+		copy(img.Pix[y * img.Stride:], img.Pix[:img.Stride])
+	}
+
+	// Burn in the frame counter.
+	digits := strconv.FormatUint(n, 10)
+	pixel := src.Height / 24
+	if pixel < 1 {
+		pixel = 1
+	}
+	left := pixel * 2
+	top := src.Height - pixel * 8
+	for y := top - pixel; y < top + pixel * 6 && y < src.Height; y++ {
+		for x := left - pixel; x < left + len(digits) * pixel * 4 && x < src.Width; x++ {
+			img.SetRGBA(x, y, color.RGBA{ 0, 0, 0, 255 })
+		}
+	}
+	for i, d := range digits {
+		glyph := testPatternDigits[d - '0']
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				if glyph[row] & (4 >> uint(col)) == 0 {
+					continue
+				}
+				x0 := left + (i * 4 + col) * pixel
+				y0 := top + row * pixel
+				for y := y0; y < y0 + pixel && y < src.Height; y++ {
+					for x := x0; x < x0 + pixel && x < src.Width; x++ {
+						img.SetRGBA(x, y, color.RGBA{ 255, 255, 255, 255 })
+					}
+				}
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{ Quality: src.Quality }); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (src *TestPatternFrameSource) Close() error {
+	return nil
+}
@@ -0,0 +1,221 @@
+package pi_launch_control
+
+import (
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"sync"
+	"time"
+)
+
+// Electrical characteristics of a simulated igniter.
+type SimulatedIgniterConfig struct {
+	// Supply voltage across the igniter while firing.
+	Voltage			float64
+	// Igniter bridge resistance, in ohms.
+	Resistance		float64
+	// Energy, in joules, delivered before the igniter burns through.
+	BurnEnergy		float64
+}
+
+func DefaultSimulatedIgniterConfig() SimulatedIgniterConfig {
+	return SimulatedIgniterConfig {
+		Voltage: 	12,
+		Resistance: 1,
+		BurnEnergy:	2,	// Burns through on the first 250ms pulse.
+	}
+}
+
+// A write made to the simulated FirePin.
+type FirePinWrite struct {
+	Level		gpio.Level
+	Timestamp	int64
+}
+
+// A simulated igniter wired to fake TestPin and FirePin gpios, with a fake arming KeyPin.
+//
+// Continuity can be toggled, and the igniter burns through (loses continuity) once enough energy has been
+// delivered through the FirePin.
+type SimulatedIgniter struct {
+	sync.Mutex
+	Config			SimulatedIgniterConfig
+
+	TestPin			*gpiotest.Pin
+	FirePin			*SimulatedFirePin
+	KeyPin			*gpiotest.Pin
+
+	delivered		float64
+	energized		time.Time
+	burnTimer		*time.Timer
+	burnedThrough	bool
+	onBurnThrough	[]func(time.Time)
+}
+
+// A fake FirePin which captures every write and reports them to the SimulatedIgniter.
+type SimulatedFirePin struct {
+	gpiotest.Pin
+	igniter			*SimulatedIgniter
+	writes			[]FirePinWrite
+}
+
+func NewSimulatedIgniter(config SimulatedIgniterConfig) *SimulatedIgniter {
+	sim := &SimulatedIgniter{
+		Config: config,
+		TestPin: &gpiotest.Pin {
+			N: "SIM_TEST",
+			L: gpio.High,
+			EdgesChan: make(chan gpio.Level, 16),
+		},
+	}
+	sim.KeyPin = &gpiotest.Pin {
+		N: "SIM_KEY",
+		L: gpio.High,
+		EdgesChan: make(chan gpio.Level, 16),
+	}
+	sim.FirePin = &SimulatedFirePin {
+		Pin: gpiotest.Pin {
+			N: "SIM_FIRE",
+			L: gpio.Low,
+		},
+		igniter: sim,
+	}
+	return sim
+}
+
+// Creates an Igniter on the simulated pins, with an intact igniter connected.
+func (sim *SimulatedIgniter) NewIgniter() (*Igniter, error) {
+	i, err := NewIgniterFromPins(sim.TestPin, sim.FirePin)
+	if err == nil {
+		sim.SetContinuity(true)
+	}
+	return i, err
+}
+
+// Creates Arming on the simulated key switch, with the key off.
+func (sim *SimulatedIgniter) NewArming() (*Arming, error) {
+	return NewArmingFromPin(sim.KeyPin)
+}
+
+// Turns the simulated arming key on or off.
+func (sim *SimulatedIgniter) SetKey(on bool) {
+	// The key switch sinks to ground.
+	l := gpio.High
+	if on {
+		l = gpio.Low
+	}
+	sim.KeyPin.Out(l)
+
+	select {
+	case sim.KeyPin.EdgesChan <- l:
+	default:
+	}
+}
+
+// Connects (or disconnects) an igniter across the test circuit.
+// Connecting resets the igniter, as if a fresh one had been installed.
+func (sim *SimulatedIgniter) SetContinuity(connected bool) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	if connected {
+		sim.delivered = 0
+		sim.burnedThrough = false
+	}
+	sim.setContinuity(connected)
+}
+
+func (sim *SimulatedIgniter) setContinuity(connected bool) {
+	// Contact sinks to ground.
+	l := gpio.High
+	if connected {
+		l = gpio.Low
+	}
+	sim.TestPin.Out(l)
+
+	select {
+	case sim.TestPin.EdgesChan <- l:
+	default:
+	}
+}
+
+// True once the igniter has burned through.
+func (sim *SimulatedIgniter) BurnedThrough() bool {
+	sim.Lock()
+	defer sim.Unlock()
+
+	return sim.burnedThrough
+}
+
+// Registers f to be called when the igniter burns through.
+func (sim *SimulatedIgniter) OnBurnThrough(f func(time.Time)) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	sim.onBurnThrough = append(sim.onBurnThrough, f)
+}
+
+// Returns a copy of every write made to the FirePin.
+func (sim *SimulatedIgniter) FireWrites() []FirePinWrite {
+	sim.Lock()
+	defer sim.Unlock()
+
+	writes := make([]FirePinWrite, len(sim.FirePin.writes))
+	copy(writes, sim.FirePin.writes)
+	return writes
+}
+
+// Power dissipated in the igniter while the FirePin is high.
+func (sim *SimulatedIgniter) power() float64 {
+	if sim.burnedThrough || sim.Config.Resistance <= 0 {
+		return 0
+	}
+	return sim.Config.Voltage * sim.Config.Voltage / sim.Config.Resistance
+}
+
+func (sim *SimulatedIgniter) fireWrite(l gpio.Level, when time.Time) {
+	sim.Lock()
+	defer sim.Unlock()
+
+	sim.FirePin.writes = append(sim.FirePin.writes, FirePinWrite{l, when.UnixNano()})
+
+	energized := !sim.energized.IsZero()
+	if l == gpio.High && !energized {
+		sim.energized = when
+		if p := sim.power(); p > 0 {
+			remaining := (sim.Config.BurnEnergy - sim.delivered) / p
+			sim.burnTimer = time.AfterFunc(time.Duration(remaining * float64(time.Second)), sim.burnThrough)
+		}
+	} else if l == gpio.Low && energized {
+		sim.delivered += sim.power() * when.Sub(sim.energized).Seconds()
+		sim.energized = time.Time{}
+		if sim.burnTimer != nil {
+			sim.burnTimer.Stop()
+			sim.burnTimer = nil
+		}
+	}
+}
+
+func (sim *SimulatedIgniter) burnThrough() {
+	sim.Lock()
+	if sim.burnedThrough || sim.energized.IsZero() {
+		sim.Unlock()
+		return
+	}
+	now := time.Now()
+	sim.delivered = sim.Config.BurnEnergy
+	sim.burnedThrough = true
+	sim.burnTimer = nil
+	sim.setContinuity(false)
+	callbacks := sim.onBurnThrough
+	sim.Unlock()
+
+	for _, f := range callbacks {
+		f(now)
+	}
+}
+
+// Out implements gpio.PinOut.
+func (p *SimulatedFirePin) Out(l gpio.Level) error {
+	err := p.Pin.Out(l)
+	p.igniter.fireWrite(l, time.Now())
+	return err
+}
@@ -0,0 +1,24 @@
+package pi_launch_control
+
+import (
+	"time"
+)
+
+// A scripted motor, which produces thrust on a simulated scale some time after ignition.
+type SimulatedMotor struct {
+	// Time from igniter burn through to first thrust.
+	Delay			time.Duration
+	Curve			ThrustCurve
+}
+
+func DefaultSimulatedMotor() SimulatedMotor {
+	return SimulatedMotor {
+		Delay: 	150 * time.Millisecond,
+		Curve: 	NewThrustCurve(1800 * time.Millisecond, 1200, 450),
+	}
+}
+
+// Lights the motor on the given scale source as if the igniter burned through at t.
+func (m SimulatedMotor) Ignite(src *SimulatedScaleSource, t time.Time) {
+	src.Ignite(t.Add(m.Delay), m.Curve)
+}
@@ -0,0 +1,190 @@
+package pi_launch_control
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device name reported by a Scale built on a SimulatedScaleSource.
+const SimulatedScaleDevice = "simulated"
+
+// A single point on a ThrustCurve.
+type ThrustPoint struct {
+	Time		time.Duration
+	Mass		float64
+}
+
+// Piecewise linear load (in calibration mass units) over time since ignition.
+type ThrustCurve []ThrustPoint
+
+// Builds a classic spike and sustain thrust curve.
+func NewThrustCurve(burn time.Duration, peak float64, sustain float64) ThrustCurve {
+	return ThrustCurve {
+		{ 0, 0 },
+		{ burn * 5 / 100, peak },
+		{ burn * 20 / 100, sustain },
+		{ burn * 85 / 100, sustain },
+		{ burn, 0 },
+	}
+}
+
+// Returns the load at d since ignition.
+func (c ThrustCurve) At(d time.Duration) float64 {
+	if len(c) == 0 || d < c[0].Time || d > c[len(c) - 1].Time {
+		return 0
+	}
+
+	i := sort.Search(len(c), func(i int) bool { return c[i].Time >= d })
+	if c[i].Time == d || i == 0 {
+		return c[i].Mass
+	}
+
+	prev := c[i - 1]
+	span := float64(c[i].Time - prev.Time)
+	return prev.Mass + (c[i].Mass - prev.Mass) * float64(d - prev.Time) / span
+}
+
+// Duration of the curve.
+func (c ThrustCurve) Duration() time.Duration {
+	if len(c) == 0 {
+		return 0
+	}
+	return c[len(c) - 1].Time
+}
+
+// Shape of the simulated load cell signal.
+type SimulatedScaleConfig struct {
+	// Raw counts with no load.
+	Baseline		uint32
+	// Raw counts per unit of calibration mass.
+	CountsPerMass	float64
+	// Standard deviation of the noise, in raw counts.
+	Noise			float64
+	// Baseline drift, in raw counts per second.
+	Drift			float64
+	// Fraction of the load carried by a second load cell on Volt1. Zero simulates a single cell.
+	Volt1Share		float64
+}
+
+func DefaultSimulatedScaleConfig() SimulatedScaleConfig {
+	return SimulatedScaleConfig {
+		Baseline: 		100000,
+		CountsPerMass:	42.5,
+		Noise: 			25,
+		Drift: 			0.5,
+	}
+}
+
+// ScaleSource that generates samples rather than reading a load cell.
+type SimulatedScaleSource struct {
+	sync.Mutex
+	Config			SimulatedScaleConfig
+
+	started			time.Time
+	load			float64
+	curve			ThrustCurve
+	ignition		time.Time
+	rand			*rand.Rand
+
+	samples			chan []byte
+	closed			bool
+}
+
+func NewSimulatedScaleSource(config SimulatedScaleConfig) *SimulatedScaleSource {
+	return &SimulatedScaleSource {
+		Config: 	config,
+		started: 	time.Now(),
+		rand: 		rand.New(rand.NewSource(time.Now().UnixNano())),
+		samples: 	make(chan []byte, 80), // One second of samples at 80hz.
+	}
+}
+
+// Sets a static load on the simulated scale, such as a calibration mass.
+func (src *SimulatedScaleSource) SetLoad(mass float64) {
+	src.Lock()
+	defer src.Unlock()
+
+	src.load = mass
+}
+
+// Begins playing the thrust curve at the given time.
+func (src *SimulatedScaleSource) Ignite(at time.Time, curve ThrustCurve) {
+	src.Lock()
+	defer src.Unlock()
+
+	src.ignition = at
+	src.curve = curve
+}
+
+// Returns the simulated load at t.
+func (src *SimulatedScaleSource) Load(t time.Time) float64 {
+	src.Lock()
+	defer src.Unlock()
+
+	return src.loadAt(t)
+}
+
+func (src *SimulatedScaleSource) loadAt(t time.Time) float64 {
+	mass := src.load
+	if src.curve != nil && !t.Before(src.ignition) {
+		mass += src.curve.At(t.Sub(src.ignition))
+	}
+	return mass
+}
+
+func (src *SimulatedScaleSource) counts(mass float64, t time.Time) uint32 {
+	v := float64(src.Config.Baseline) +
+		src.Config.Drift * t.Sub(src.started).Seconds() +
+		src.Config.CountsPerMass * mass +
+		src.rand.NormFloat64() * src.Config.Noise
+	if v < 0 {
+		return 0
+	}
+	return uint32(v)
+}
+
+func (src *SimulatedScaleSource) Trigger(t time.Time) error {
+	src.Lock()
+	defer src.Unlock()
+
+	if src.closed {
+		return os.ErrClosed
+	}
+
+	samp := make([]byte, ScaleSampleSize)
+	load := src.loadAt(t)
+	binary.LittleEndian.PutUint32(samp[0:4], src.counts(load * (1 - src.Config.Volt1Share), t))
+	binary.LittleEndian.PutUint32(samp[4:8], src.counts(load * src.Config.Volt1Share, t))
+	binary.LittleEndian.PutUint64(samp[8:16], uint64(t.UnixNano()))
+
+	select {
+	case src.samples <- samp:
+	default:
+		// Nobody is reading, drop it like an overrun hardware buffer would.
+	}
+	return nil
+}
+
+func (src *SimulatedScaleSource) Read(p []byte) (int, error) {
+	samp, open := <- src.samples
+	if !open {
+		return 0, io.EOF
+	}
+	return copy(p, samp), nil
+}
+
+func (src *SimulatedScaleSource) Close() error {
+	src.Lock()
+	defer src.Unlock()
+
+	if !src.closed {
+		src.closed = true
+		close(src.samples)
+	}
+	return nil
+}
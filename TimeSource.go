@@ -0,0 +1,123 @@
+package pi_launch_control
+
+import (
+	"sync"
+	"time"
+)
+
+// Where a Mission gets the time from. Replaceable so missions can be driven faster (or slower) than the wall clock.
+type TimeSource interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// The parts of time.Ticker a Mission uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// TimeSource on the system clock.
+var WallTime TimeSource = wallTime{}
+
+type wallTime struct{}
+
+func (wallTime) Now() time.Time {
+	return time.Now()
+}
+
+func (wallTime) NewTicker(d time.Duration) Ticker {
+	return wallTicker{time.NewTicker(d)}
+}
+
+type wallTicker struct {
+	*time.Ticker
+}
+
+func (t wallTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// TimeSource which only moves when Advance is called.
+type ManualTimeSource struct {
+	sync.Mutex
+	now			time.Time
+	tickers		[]*manualTicker
+}
+
+type manualTicker struct {
+	source		*ManualTimeSource
+	c			chan time.Time
+	period		time.Duration
+	next		time.Time
+	stopped		bool
+}
+
+func NewManualTimeSource(start time.Time) *ManualTimeSource {
+	return &ManualTimeSource {
+		now: start,
+	}
+}
+
+func (s *ManualTimeSource) Now() time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.now
+}
+
+func (s *ManualTimeSource) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	t := &manualTicker {
+		source: 	s,
+		c: 			make(chan time.Time, 1),
+		period: 	d,
+		next: 		s.now.Add(d),
+	}
+	s.tickers = append(s.tickers, t)
+	return t
+}
+
+// Moves time forward by d, ticking any tickers which come due. Like time.Ticker, ticks are dropped for slow
+// receivers.
+func (s *ManualTimeSource) Advance(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.now = s.now.Add(d)
+	for _, t := range s.tickers {
+		if t.stopped || t.next.After(s.now) {
+			continue
+		}
+		select {
+		case t.c <- t.next:
+		default:
+		}
+		for !t.next.After(s.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	t.source.Lock()
+	defer t.source.Unlock()
+
+	t.stopped = true
+	for idx, st := range t.source.tickers {
+		if st == t {
+			t.source.tickers = append(t.source.tickers[:idx], t.source.tickers[idx + 1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pi_launch_control
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unit a Scale is calibrated in, and masses are reported in.
+type MassUnit string
+
+const (
+	Grams		MassUnit = "g"
+	Kilograms	MassUnit = "kg"
+	Pounds		MassUnit = "lb"
+)
+
+const (
+	// Meters per second squared.
+	standardGravity = 9.80665
+	// Newtons per pound-force.
+	poundForce = 4.4482216152605
+)
+
+// Parses a unit name or symbol, ie: "kg" or "kilograms". Empty is taken as grams, the unit used before scales
+// carried one.
+func ParseMassUnit(name string) (MassUnit, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "g", "gram", "grams":
+		return Grams, nil
+	case "kg", "kilogram", "kilograms":
+		return Kilograms, nil
+	case "lb", "lbs", "pound", "pounds":
+		return Pounds, nil
+	}
+	return "", fmt.Errorf("unknown mass unit %q, use g, kg or lb", name)
+}
+
+// Kilograms per unit.
+func (u MassUnit) kilograms() float64 {
+	switch u {
+	case Kilograms:
+		return 1
+	case Pounds:
+		return 0.45359237
+	}
+	return 0.001
+}
+
+// Converts a mass in u to another unit.
+func (u MassUnit) Convert(mass float64, to MassUnit) float64 {
+	return mass * u.kilograms() / to.kilograms()
+}
+
+// The force, in newtons, of a mass in u under standard gravity.
+func (u MassUnit) Newtons(mass float64) float64 {
+	return mass * u.kilograms() * standardGravity
+}
+
+// The force, in pound-force, of a mass in u under standard gravity.
+func (u MassUnit) PoundsForce(mass float64) float64 {
+	return u.Newtons(mass) / poundForce
+}
@@ -0,0 +1,146 @@
+// Package analysis reduces a recorded thrust curve to the numbers used to characterize a motor.
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Upper bound of the A impulse class, in newton seconds. Each class doubles the one before it.
+const classAUpper = 2.5
+
+// The largest NAR impulse class.
+const classLargest = 'O'
+
+// A single thrust measurement.
+type Point struct {
+	// Unix nanoseconds.
+	Time			int64
+	// Newtons.
+	Thrust			float64
+}
+
+type Config struct {
+	// Fraction of peak thrust which marks the start and end of the burn.
+	Threshold		float64
+}
+
+func DefaultConfig() Config {
+	return Config {
+		Threshold: 	0.05,
+	}
+}
+
+// The characteristics of a burn.
+//
+// swagger:model AnalysisResult
+type Result struct {
+	// Unix nanoseconds the thrust first rose above, and last fell below, the threshold.
+	BurnStart		int64
+	BurnEnd			int64
+	// Seconds between BurnStart and BurnEnd.
+	BurnTime		float64
+	// Newtons.
+	PeakThrust		float64
+	PeakTime		int64
+	AverageThrust	float64
+	// Newton seconds.
+	TotalImpulse	float64
+	// NAR impulse class, ie: "F", and how far into the class the impulse is, as a percentage.
+	Class			string
+	ClassPercent	float64
+	// Class and average thrust, ie: "F32".
+	Designation		string
+	// Number of points within the burn.
+	Samples			int
+}
+
+var ErrNoBurn = errors.New("no burn found")
+
+// Analyzes a thrust curve. Points need not be sorted.
+func Analyze(points []Point, config Config) (Result, error) {
+	var r Result
+
+	if config.Threshold <= 0 || config.Threshold >= 1 {
+		return r, errors.New("Threshold must be between 0 and 1")
+	}
+	if len(points) < 2 {
+		return r, ErrNoBurn
+	}
+
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Time < sorted[b].Time })
+
+	peak := 0
+	for idx, p := range sorted {
+		if p.Thrust > sorted[peak].Thrust {
+			peak = idx
+		}
+	}
+	if sorted[peak].Thrust <= 0 {
+		return r, ErrNoBurn
+	}
+	r.PeakThrust = sorted[peak].Thrust
+	r.PeakTime = sorted[peak].Time
+
+	// Walk out from the peak until thrust falls below the threshold.
+	threshold := r.PeakThrust * config.Threshold
+	start := peak
+	for start > 0 && sorted[start - 1].Thrust >= threshold {
+		start--
+	}
+	end := peak
+	for end < len(sorted) - 1 && sorted[end + 1].Thrust >= threshold {
+		end++
+	}
+	if start == end {
+		return r, ErrNoBurn
+	}
+
+	r.BurnStart = sorted[start].Time
+	r.BurnEnd = sorted[end].Time
+	r.BurnTime = float64(r.BurnEnd - r.BurnStart) / 1e9
+	r.Samples = end - start + 1
+
+	// Trapezoidal integration over the burn.
+	for idx := start + 1; idx <= end; idx++ {
+		dt := float64(sorted[idx].Time - sorted[idx - 1].Time) / 1e9
+		r.TotalImpulse += (sorted[idx].Thrust + sorted[idx - 1].Thrust) / 2 * dt
+	}
+	r.AverageThrust = r.TotalImpulse / r.BurnTime
+
+	r.Class, r.ClassPercent = ImpulseClass(r.TotalImpulse)
+	r.Designation = fmt.Sprintf("%s%.0f", r.Class, r.AverageThrust)
+
+	return r, nil
+}
+
+// The NAR impulse class for a total impulse in newton seconds, and how far into the class it is, as a percentage.
+//
+// Classes below A are named 1/2A, 1/4A and so on. There are no classes past O, so larger impulses are reported as O,
+// more than 100% into it.
+func ImpulseClass(impulse float64) (string, float64) {
+	if impulse <= 0 {
+		return "", 0
+	}
+
+	// Classes are (upper / 2, upper].
+	idx := int(math.Ceil(math.Log2(impulse / classAUpper)))
+	if idx > classLargest - 'A' {
+		idx = classLargest - 'A'
+	}
+	upper := classAUpper * math.Pow(2, float64(idx))
+	lower := upper / 2
+	percent := (impulse - lower) / (upper - lower) * 100
+
+	var class string
+	if idx >= 0 {
+		class = string(rune('A' + idx))
+	} else {
+		class = fmt.Sprintf("1/%dA", 1 << uint(-idx))
+	}
+	return class, percent
+}
@@ -0,0 +1,25 @@
+package analysis
+
+import (
+	"testing"
+)
+
+func TestImpulseClass(t *testing.T) {
+	for _, c := range []struct {
+		impulse		float64
+		class		string
+		percent		float64
+	} {
+		{ 0, 		"", 		0 },
+		{ 0.5, 		"1/4A", 	60 },
+		{ 2.5, 		"A", 		100 },
+		{ 3.75, 	"B", 		50 },
+		{ 40960, 	"O", 		100 },
+		{ 61440, 	"O", 		200 },
+	} {
+		class, percent := ImpulseClass(c.impulse)
+		if class != c.class || percent != c.percent {
+			t.Errorf("%.2f Ns: %s %.0f%%, expected %s %.0f%%", c.impulse, class, percent, c.class, c.percent)
+		}
+	}
+}
@@ -17,31 +17,58 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/GeertJohan/go.rice"
+	"io"
+	"io/ioutil"
 	"github.com/bvarner/pi-launch-control"
+	"github.com/bvarner/pi-launch-control/analysis"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var mission *pi_launch_control.Mission
 
+// Guards the mission variable. The Mission itself is safe to use from any goroutine.
+var missionLock sync.Mutex
+
 var igniter *pi_launch_control.Igniter
 
+var arming *pi_launch_control.Arming
+
 var scale *pi_launch_control.Scale
 
 var camera *pi_launch_control.Camera
 
 var broker *pi_launch_control.Broker
 
+var replay *pi_launch_control.Replay
+
+var store *pi_launch_control.MissionStore
+
+var preflight *pi_launch_control.Preflight
+
+var audit *pi_launch_control.AuditLog
+
 var handler http.Handler
 
+// Simulated devices, when running without hardware.
+var simScale *pi_launch_control.SimulatedScaleSource
+
+var simIgniter *pi_launch_control.SimulatedIgniter
+
+var simMotor = pi_launch_control.DefaultSimulatedMotor()
+
 // swagger:operation GET /scale getScale
 //
 // Returns the scale state.
@@ -56,6 +83,13 @@ var handler http.Handler
 //       "$ref": "#/definitions/Scale"
 func ScaleSettingsControl(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
+		// Reopening closes the scale the mission is recording from.
+		if missionID() != "" {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - Mission Underway"))
+			return
+		}
+
 		var nscale *pi_launch_control.Scale
 		json.NewDecoder(r.Body).Decode(nscale);
 
@@ -64,30 +98,107 @@ func ScaleSettingsControl(w http.ResponseWriter, r *http.Request) {
 			nscale = scale
 		}
 
-		nscale, err := pi_launch_control.NewScale(nscale.Device, nscale.TriggerC, nscale.Trigger);
-		if err != nil {
+		if err := reopenScale(nscale, nil); err != nil {
 			fmt.Println("Error updating scale.", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("500 - Internal Server Error"))
 			return
 		}
-		scale = nscale
 	}
 
 	if r.Method == "GET" || r.Method == "POST" {
-		json.NewEncoder(w).Encode(scale.Read())
+		unit, convert, err := requestUnit(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		sample := scale.Read()
+		if convert {
+			sample = sample.In(unit)
+		}
+		json.NewEncoder(w).Encode(sample)
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("500 - Method Not Supported"));
 	}
 }
 
-// swagger: operation GET /scale/tare
+// Replaces the scale with a new one for the given device settings, releasing the previous device first so it can be
+// re-opened. The filter and channel tares carry over.
+//
+// If the new scale can't be opened, undo (when given) is called on the previous scale, ie: to put back the settings
+// which were being changed, and its device re-opened.
+func reopenScale(nscale *pi_launch_control.Scale, undo func(previous *pi_launch_control.Scale)) error {
+	previous := scale
+	filter := previous.Filter()
+	tares := previous.Calibration()
+	wasOpen := previous.IsInitialized()
+	previous.Close()
+
+	opened, err := openScale(nscale.Device, nscale.TriggerC, nscale.Trigger)
+	if err != nil {
+		if opened != nil {
+			opened.Close()
+		}
+		// Don't leave a working scale closed for the rest of the session.
+		if wasOpen {
+			if undo != nil {
+				undo(previous)
+			}
+			if restored, rerr := openScale(previous.Device, previous.TriggerC, previous.Trigger); rerr == nil {
+				restored.SetFilter(filter)
+				restored.RestoreTares(tares)
+				restored.AddListener(broker.Outgoing)
+				scale = restored
+			} else {
+				fmt.Println("Unable to restore scale.", rerr)
+			}
+		}
+		return err
+	}
+
+	opened.SetFilter(filter)
+	opened.RestoreTares(tares)
+	opened.AddListener(broker.Outgoing)
+	scale = opened
+	return nil
+}
+
+func openScale(device string, trig <- chan time.Time, trigger string) (*pi_launch_control.Scale, error) {
+	if device == pi_launch_control.SimulatedScaleDevice {
+		return newSimulatedScale(trig)
+	}
+	return pi_launch_control.NewScale(device, trig, trigger)
+}
+
+// The ?channel= query param, or fallback if there isn't one.
+func requestChannel(r *http.Request, fallback int) (int, error) {
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(channel)
+}
+
+// Tares every enabled channel, or only the one given by ?channel=.
+//
+// swagger: operation GET /scale/tare?channel=
 func TareScaleControl(w http.ResponseWriter, r *http.Request) {
-	if scale.Initialized && (r.Method == "GET" || r.Method == "POST") {
-		scale.Tare()
+	if scale.IsInitialized() && (r.Method == "GET" || r.Method == "POST") {
+		channel, err := requestChannel(r, -1)
+		if err == nil && channel == -1 {
+			scale.Tare()
+		} else if err == nil {
+			err = scale.TareChannel(channel)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - " + err.Error()))
+			return
+		}
 		json.NewEncoder(w).Encode(scale)
-	} else if scale.Initialized {
+	} else if scale.IsInitialized() {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("500 - Method Not Supported"))
 	} else {
@@ -97,18 +208,45 @@ func TareScaleControl(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// Adds (GET, POST) or removes (DELETE) a known mass from a channel's calibration, and optionally sets the unit
+// masses are in and the order of the channel's calibration fit. The channel defaults to 0.
+//
+// swagger: operation POST /scale/calibrate?mass=&channel=&unit=&order=
+// swagger: operation DELETE /scale/calibrate?mass=&channel=
 func CalibrateScaleControl(w http.ResponseWriter, r *http.Request) {
-	if scale.Initialized && (r.Method == "GET" || r.Method == "POST") {
-		keys, ok := r.URL.Query()["mass"]
-		if ok {
-			mass, err := strconv.Atoi(keys[0])
-			if err == nil {
-				scale.Calibrate(mass)
-				json.NewEncoder(w).Encode(scale)
+	if scale.IsInitialized() && (r.Method == "GET" || r.Method == "POST" || r.Method == "DELETE") {
+		query := r.URL.Query()
+		channel, err := requestChannel(r, 0)
+		unit, convert, uerr := requestUnit(r)
+		if err == nil {
+			err = uerr
+		}
+		if err == nil && convert {
+			err = scale.SetUnit(unit)
+		}
+		if order := query.Get("order"); err == nil && order != "" {
+			var o int
+			if o, err = strconv.Atoi(order); err == nil {
+				err = scale.SetFitOrder(channel, o)
 			}
+		}
+		if mass := query.Get("mass"); err == nil && mass != "" {
+			var m int
+			if m, err = strconv.Atoi(mass); err == nil {
+				if r.Method == "DELETE" {
+					err = scale.RemoveCalibrationPoint(channel, m)
+				} else {
+					err = scale.CalibrateChannel(channel, m)
+				}
+			}
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - " + err.Error()))
 			return
 		}
-	} else if scale.Initialized {
+		json.NewEncoder(w).Encode(scale)
+	} else if scale.IsInitialized() {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("500 - Method Not Supported"));
 	} else {
@@ -117,6 +255,156 @@ func CalibrateScaleControl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Enables or disables a load cell channel, and sets its weight in the combined mass. Tares carry over when the device
+// is reopened to change its channels, a newly enabled channel reads TaredAt 0 until it's tared. If the device can't
+// be reopened, the channel is put back as it was. Refused while a mission is underway.
+//
+// swagger: operation POST /scale/channel?channel=&enabled=&weight=
+func ScaleChannelControl(w http.ResponseWriter, r *http.Request) {
+	if scale == nil || !scale.IsInitialized() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Scale Not Present"))
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+	// Changing channels may reopen the scale, and would change how the rest of the burn is measured either way.
+	if missionID() != "" {
+		w.WriteHeader(http.StatusExpectationFailed)
+		w.Write([]byte("417 - Mission Underway"))
+		return
+	}
+
+	query := r.URL.Query()
+	channel, err := requestChannel(r, -1)
+	if err == nil && channel == -1 {
+		err = errors.New("channel is required")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	current := scale.Calibration()
+	if channel < 0 || channel >= len(current.Channels) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("scale channel must be between 0 and %d", len(current.Channels) - 1)))
+		return
+	}
+	enabled, weight := current.Channels[channel].Enabled, current.Channels[channel].Weight
+	if e := query.Get("enabled"); e != "" {
+		enabled, err = strconv.ParseBool(e)
+	}
+	if wt := query.Get("weight"); err == nil && wt != "" {
+		weight, err = strconv.ParseFloat(wt, 64)
+	}
+	if err == nil {
+		err = scale.ConfigureChannel(channel, enabled, weight)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusExpectationFailed)
+		w.Write([]byte("417 - " + err.Error()))
+		return
+	}
+
+	// IIO devices only read the channels enabled when they're opened.
+	if enabled != current.Channels[channel].Enabled && scale.Device != pi_launch_control.SimulatedScaleDevice {
+		previous := current.Channels[channel]
+		err := reopenScale(scale, func(s *pi_launch_control.Scale) {
+			s.ConfigureChannel(channel, previous.Enabled, previous.Weight)
+		})
+		if err != nil {
+			fmt.Println("Error updating scale.", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - Internal Server Error"))
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(scale)
+}
+
+// Returns (GET), replaces (PUT, POST) or removes (DELETE) the scale's filter.
+//
+// swagger: operation GET /scale/filter
+// swagger: operation PUT /scale/filter
+// swagger: operation DELETE /scale/filter
+func ScaleFilterControl(w http.ResponseWriter, r *http.Request) {
+	if scale == nil || !scale.IsInitialized() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Scale Not Present"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "PUT", "POST":
+		var filter pi_launch_control.ScaleFilter
+		if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err := scale.SetFilter(filter); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	case "DELETE":
+		scale.SetFilter(pi_launch_control.ScaleFilter{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+	json.NewEncoder(w).Encode(scale.Filter())
+}
+
+// Exports, imports or clears the scale's saved calibration.
+//
+// swagger: operation GET /scale/calibration
+// swagger: operation PUT /scale/calibration
+// swagger: operation DELETE /scale/calibration
+func ScaleCalibrationControl(w http.ResponseWriter, r *http.Request) {
+	if scale == nil || !scale.IsInitialized() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Scale Not Present"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Disposition", "attachment; filename=\"calibration.json\"")
+		json.NewEncoder(w).Encode(scale.Calibration())
+	case "PUT", "POST":
+		var calibration pi_launch_control.ScaleCalibration
+		if err := json.NewDecoder(r.Body).Decode(&calibration); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err := scale.SetCalibration(calibration); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(w).Encode(scale.Calibration())
+	case "DELETE":
+		if err := scale.ClearCalibration(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+	}
+}
+
 func RootHandler(w http.ResponseWriter, r *http.Request) {
 	// Push some things if we know what our request is.
 	if r.URL.Path == "/" || r.URL.Path == "/index.html" {
@@ -151,6 +439,7 @@ func ClockControl(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte(err.Error()))
 			} else {
+				preflight.ClockSet()
 				w.WriteHeader(http.StatusOK)
 			}
 		}
@@ -169,134 +458,305 @@ func IgniterControl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Preflight Checklist.
+//
+// GET /preflight runs every check and returns the PreflightReport.
+func PreflightControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(preflight.Run(igniter, scale, camera))
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+	}
+}
+
+// Arming Control.
+//
+// GET /arming returns the ArmingState. POST /arming/arm software arms (the key must be on), POST /arming/safe safes.
+func ArmingControl(w http.ResponseWriter, r *http.Request) {
+	if arming == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Arming Not Present"))
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/arming" && r.Method == "GET":
+	case r.URL.Path == "/arming/arm" && r.Method == "POST":
+		if err := arming.Arm(); err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - " + err.Error()))
+			return
+		}
+	case r.URL.Path == "/arming/safe" && r.Method == "POST":
+		arming.Safe()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+	json.NewEncoder(w).Encode(arming.GetState())
+}
+
 // Launch / Test Sequence Control.
+//
+// /mission/start accepts an optional MissionConfig body describing the countdown and recording timeline,
+// along with the mission's MissionMetadata as "Metadata".
+// Failed preflight checks prevent the start, unless ?override=true is given.
+// A "Mode": "triggered" mission doesn't need arming or fire the igniter. It records, from PreTrigger before,
+// once the calibrated scale sees ThrustThreshold newtons, until thrust stays under ReleaseThreshold for ReleaseTime.
+// GET /mission/metadata returns the current mission's metadata, POST /mission/metadata replaces it.
 func MissionControl(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/mission/start":
-		if mission != nil {
-			if mission.Aborted || mission.Complete {
-				// Make sure we call this from the server side.
-				mission.Abort()
-				mission = nil
-				// So that we can carry on now with a new mission.
-			} else {
+		// One start at a time.
+		missionLock.Lock()
+		defer missionLock.Unlock()
+
+		if mission != nil && !mission.Finished() {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - Mission Already Underway"))
+			return
+		}
+
+		// Live data takes over from any replay.
+		if replay != nil {
+			replay.Stop()
+		}
+
+		// The timeline may be supplied as a MissionConfig in the body, otherwise the defaults are used.
+		start := struct {
+			pi_launch_control.MissionConfig
+			Metadata	pi_launch_control.MissionMetadata
+		}{
+			MissionConfig: pi_launch_control.DefaultMissionConfig(),
+		}
+		if r.Body != nil {
+			if err := json.NewDecoder(r.Body).Decode(&start); err != nil && err != io.EOF {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		if err := start.MissionConfig.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err := start.Metadata.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if !start.Triggered() {
+			if igniter == nil {
+				w.WriteHeader(http.StatusExpectationFailed)
+				w.Write([]byte("417 - Igniter not present"))
+				return
+			}
+
+			// Nobody re-arms until a hangfire lockout has expired.
+			if igniter.LockedOut() {
+				w.WriteHeader(http.StatusExpectationFailed)
+				w.Write([]byte(fmt.Sprintf("417 - Igniter Locked Out for %.0f seconds", igniter.LockoutRemaining().Seconds())))
+				return
+			}
+
+			// Both the key and software must be armed.
+			if !igniter.IsArmed() {
+				w.WriteHeader(http.StatusExpectationFailed)
+				w.Write([]byte("417 - Not Armed"))
+				return
+			}
+
+			// Check to verify Igniter is OK.
+			if !igniter.IsReady() {
 				w.WriteHeader(http.StatusExpectationFailed)
-				w.Write([]byte("417 - Mission Already Underway"))
+				w.Write([]byte("417 - Check Igniter Connections."))
 				return
 			}
 		}
 
-		// Check to verify Igniter is OK.
-		if !igniter.IsReady() {
+		// Triggered missions never fire, so they don't need the igniter. Start refuses them without a calibrated scale.
+		report := preflight.Run(igniter, scale, camera)
+		var skip []string
+		if start.Triggered() {
+			skip = append(skip, pi_launch_control.PreflightIgniterCheck)
+		}
+		if checks := report.Failed(skip...); len(checks) > 0 {
+			keys, ok := r.URL.Query()["override"]
+			if ok {
+				report.Override, _ = strconv.ParseBool(keys[0])
+			}
+			if !report.Override {
+				// Let clients know the mission was refused, and why. It isn't stored, nor does it replace the
+				// current mission, so the last test stays the one downloaded.
+				failed := make([]string, 0)
+				for _, c := range checks {
+					failed = append(failed, c.Name + ": " + c.Message)
+				}
+				refused := pi_launch_control.NewMission(igniter, scale, camera, start.MissionConfig, start.Metadata)
+				refused.Preflight = &report
+				refused.AbortFor(pi_launch_control.AbortPreflight, strings.Join(failed, "; "))
+				sendMission(refused)
+
+				w.WriteHeader(http.StatusExpectationFailed)
+				json.NewEncoder(w).Encode(report)
+				return
+			}
+		}
+
+		nmission := pi_launch_control.NewMission(igniter, scale, camera, start.MissionConfig, start.Metadata)
+		nmission.Preflight = &report
+		nmission.SetStore(store)
+		if err := nmission.Start(context.Background(), broker); err != nil {
+			if start.Triggered() {
+				w.WriteHeader(http.StatusExpectationFailed)
+				w.Write([]byte("417 - " + err.Error()))
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		mission = nmission
+	case "/mission/abort":
+		mission := currentMission()
+		if mission == nil || mission.Finished() {
 			w.WriteHeader(http.StatusExpectationFailed)
-			w.Write([]byte("417 - Check Igniter Connections."))
+			w.Write([]byte("417 - No Mission in Progress"))
 			return
 		}
 
-		mission = pi_launch_control.NewMission(igniter, scale, camera)
-		mission.Start(broker)
-	case "/mission/abort":
+		// Returns once everything is safe.
+		if err := mission.Abort(); err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - " + err.Error()))
+			return
+		}
+	case "/mission/hold", "/mission/resume":
+		mission := currentMission()
 		if mission == nil {
 			w.WriteHeader(http.StatusExpectationFailed)
 			w.Write([]byte("417 - No Mission in Progress"))
 			return
 		}
 
-		mission.Abort()
-		mission = nil
+		var err error
+		if r.URL.Path == "/mission/hold" {
+			err = mission.Hold()
+		} else {
+			err = mission.Resume()
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - " + err.Error()))
+			return
+		}
+	case "/mission/metadata":
+		mission := currentMission()
+		if mission == nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - No Mission in Progress"))
+			return
+		}
+
+		if r.Method == "POST" {
+			var metadata pi_launch_control.MissionMetadata
+			if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			if err := mission.SetMetadata(metadata); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		} else if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("500 - Method Not Supported"))
+			return
+		}
+
+		mission.Lock()
+		json.NewEncoder(w).Encode(mission.Metadata)
+		mission.Unlock()
+		return
 	case "/mission/download":
 		if r.Method == "GET" {
+			// Serve the stored copy of the last mission, if we have one.
+			if store != nil && store.Latest() != "" {
+				serveMissionArchive(w, r, store.Latest())
+				return
+			}
+
+			unit, convert, err := requestUnit(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
 			buf := new(bytes.Buffer)
 			filename := ""
 
-			if igniter.GetFirstRecorded() != nil {
-				zw := zip.NewWriter(buf)
-
+			// Without a store, only what the igniter recorded can be told apart from an earlier mission.
+			if igniter != nil && igniter.GetFirstRecorded() != nil {
 				// Create an array / slice of devices to get data from.
-				// If we get an error on doing anything with a device file, we bail.
-				var err error = nil
-
 				devices := make([]map[*zip.FileHeader][]byte, 1)
 
-				total := 0
-				complete := 0
-
 				// Always add the igniter.
 				devices[0] = igniter.GetRecordedData()
-				total += len(devices[0])
-				if scale.Initialized {
-					devices = append(devices, scale.GetRecordedData())
-					total += len(devices[len(devices)-1])
-				}
-				if camera.Initialized {
-					devices = append(devices, camera.GetRecordedData())
-					total += len(devices[len(devices)-1])
-				}
-
-				filename = fmt.Sprintf("%d", igniter.GetFirstRecorded().Timestamp)
-
-				// If we have a name query param, add it.
-				namekeys, ok := r.URL.Query()["name"]
-				if ok {
-					filename = fmt.Sprintf("%s-%s", filename, namekeys[0])
-				}
-
-				// Append the .zip.
-				filename = fmt.Sprintf("%s.zip", filename)
-				// And away we go.
-				for _, data := range devices {
-					if err != nil {
-						break;
+				if mission := currentMission(); mission != nil {
+					mission.Lock()
+					metadata, err := mission.Metadata.ArchiveFile()
+					result := mission.Analysis
+					mission.Unlock()
+					if err == nil {
+						devices = append(devices, metadata)
 					}
-					//
-					for fname, fdata := range data {
-						f, ferr := zw.CreateHeader(fname)
-						if ferr != nil {
-							err = ferr
-							break
+					if result != nil {
+						if file, err := pi_launch_control.AnalysisArchiveFile(result); err == nil {
+							devices = append(devices, file)
 						}
-						_, ferr = f.Write(fdata)
-						if ferr != nil {
-							err = ferr
-							break
-						}
-						complete++;
-						obj := map[string]interface{}{
-							"Total":    total,
-							"Complete": complete,
-							"Error":    nil,
+					}
+					if audit != nil {
+						if actions, err := audit.ArchiveFile(mission.ID); err == nil {
+							devices = append(devices, actions)
 						}
-
-						statusdata, err := json.Marshal(obj)
-						if err == nil {
-							s := fmt.Sprintf("event: %s\ndata: %s\n", "MissionPacking", string(statusdata))
-							broker.Outgoing <- s
+					}
+				}
+				if scale.IsInitialized() {
+					recorded := scale.GetRecordedData()
+					if convert {
+						for header, data := range recorded {
+							if header.Name == "scale.json" {
+								recorded[header], _ = pi_launch_control.ConvertSamples(data, unit)
+							}
 						}
 					}
+					devices = append(devices, recorded)
 				}
-
-				// Still no error?
-				if err == nil {
-					err = zw.Close()
+				if camera.IsInitialized() {
+					devices = append(devices, camera.GetRecordedData())
 				}
 
-				if err != nil {
-					obj := map[string]interface{}{
-						"Total":    total,
-						"Complete": complete,
-						"Error":    nil,
-					}
+				filename = missionFilename(r, fmt.Sprintf("%d", igniter.GetFirstRecorded().Timestamp))
 
-					statusdata, err := json.Marshal(obj)
-					if err == nil {
-						s := fmt.Sprintf("event: %s\ndata: %s\n", "MissionPacking", string(statusdata))
-						broker.Outgoing <- s
-					}
+				// And away we go.
+				complete, err := pi_launch_control.WriteMissionArchive(buf, nil, devices, func(total int, complete int) {
+					sendMissionPacking(total, complete, nil)
+				})
+				if err != nil {
+					sendMissionPacking(complete, complete, err)
 
 					w.WriteHeader(http.StatusInternalServerError)
 					w.Write([]byte(err.Error()))
 					return
 				}
+				preflight.Downloaded(igniter.GetFirstRecorded().Timestamp)
 			}
 
 			if filename == "" {
@@ -322,6 +782,512 @@ func MissionControl(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// Creates a Scale on a fresh simulated load cell.
+func newSimulatedScale(trig <- chan time.Time) (*pi_launch_control.Scale, error) {
+	simScale = pi_launch_control.NewSimulatedScaleSource(pi_launch_control.DefaultSimulatedScaleConfig())
+	return pi_launch_control.NewScaleFromSource(pi_launch_control.SimulatedScaleDevice, simScale, trig)
+}
+
+// Manipulates the simulated devices.
+//
+// /simulate/scale?load=<mass> places a static load on the simulated scale.
+// /simulate/igniter?continuity=<true|false> connects or removes the simulated igniter.
+// /simulate/igniter?key=<true|false> turns the simulated arming key on or off.
+func SimulationControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/simulate/scale":
+		if simScale == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Scale Not Simulated"))
+			return
+		}
+		keys, ok := r.URL.Query()["load"]
+		if ok {
+			load, err := strconv.ParseFloat(keys[0], 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			simScale.SetLoad(load)
+		}
+	case "/simulate/igniter":
+		if simIgniter == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Igniter Not Simulated"))
+			return
+		}
+		keys, ok := r.URL.Query()["continuity"]
+		if ok {
+			connected, err := strconv.ParseBool(keys[0])
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			simIgniter.SetContinuity(connected)
+		}
+		keys, ok = r.URL.Query()["key"]
+		if ok {
+			on, err := strconv.ParseBool(keys[0])
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			simIgniter.SetKey(on)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Replay of a downloaded mission archive.
+//
+// POST /replay/load with the archive as the body (and optional ?name=) loads a replay.
+// POST /replay/load?id=<mission id> loads a stored mission.
+// /replay/play?speed=, /replay/pause, /replay/seek?position=<seconds> and /replay/stop control playback.
+func ReplayControl(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/replay" {
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(replay)
+		} else {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("500 - Method Not Supported"))
+		}
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+
+	if r.URL.Path == "/replay/load" {
+		var archive []byte
+		var err error
+
+		// Either a stored mission, or an uploaded archive.
+		idkeys, ok := r.URL.Query()["id"]
+		if ok && store != nil {
+			var path string
+			path, err = store.Archive(idkeys[0])
+			if err == nil {
+				archive, err = ioutil.ReadFile(path)
+			}
+		} else {
+			archive, err = ioutil.ReadAll(r.Body)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		name := ""
+		namekeys, ok := r.URL.Query()["name"]
+		if ok {
+			name = namekeys[0]
+		}
+
+		nreplay, err := pi_launch_control.NewReplay(name, archive)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if replay != nil {
+			replay.Stop()
+		}
+		replay = nreplay
+		json.NewEncoder(w).Encode(replay)
+		return
+	}
+
+	if replay == nil {
+		w.WriteHeader(http.StatusExpectationFailed)
+		w.Write([]byte("417 - No Replay Loaded"))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/replay/play":
+		if mission := currentMission(); mission != nil && !mission.Finished() {
+			w.WriteHeader(http.StatusExpectationFailed)
+			w.Write([]byte("417 - Mission Underway"))
+			return
+		}
+
+		keys, ok := r.URL.Query()["speed"]
+		if ok {
+			speed, err := strconv.ParseFloat(keys[0], 64)
+			if err == nil {
+				err = replay.SetSpeed(speed)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		// Quiet the live devices while the replay plays.
+		mute := make([]*pi_launch_control.Emitter, 0)
+		if igniter != nil {
+			mute = append(mute, &igniter.Emitter)
+		}
+		if scale != nil {
+			mute = append(mute, &scale.Emitter)
+		}
+		if camera != nil {
+			mute = append(mute, &camera.Emitter)
+		}
+		replay.Play(broker, camera, mute...)
+	case "/replay/pause":
+		replay.Pause()
+	case "/replay/seek":
+		keys, ok := r.URL.Query()["position"]
+		if ok {
+			position, err := strconv.ParseFloat(keys[0], 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			replay.Seek(time.Duration(position * float64(time.Second)))
+		}
+	case "/replay/stop":
+		replay.Stop()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+		return
+	}
+	json.NewEncoder(w).Encode(replay)
+}
+
+// Builds the download filename from the base and any ?name= query param.
+func missionFilename(r *http.Request, base string) string {
+	filename := base
+
+	// If we have a name query param, add it.
+	namekeys, ok := r.URL.Query()["name"]
+	if ok {
+		filename = fmt.Sprintf("%s-%s", filename, namekeys[0])
+	}
+
+	// Append the .zip.
+	return fmt.Sprintf("%s.zip", filename)
+}
+
+// Sends a Mission event for a mission which isn't running, and so can't send its own.
+func sendMission(m *pi_launch_control.Mission) {
+	m.Lock()
+	data, err := json.Marshal(m)
+	m.Unlock()
+
+	if err == nil {
+		broker.Outgoing <- fmt.Sprintf("event: %s\ndata: %s\n", "Mission", string(data))
+	}
+}
+
+// Lets clients know how packing up a mission archive is going.
+func sendMissionPacking(total int, complete int, err error) {
+	obj := map[string]interface{}{
+		"Total":    total,
+		"Complete": complete,
+		"Error":    nil,
+	}
+	if err != nil {
+		obj["Error"] = err.Error()
+	}
+
+	statusdata, merr := json.Marshal(obj)
+	if merr == nil {
+		s := fmt.Sprintf("event: %s\ndata: %s\n", "MissionPacking", string(statusdata))
+		broker.Outgoing <- s
+	}
+}
+
+// The mass unit asked for with ?unit=, and whether one was.
+func requestUnit(r *http.Request) (pi_launch_control.MassUnit, bool, error) {
+	name := r.URL.Query().Get("unit")
+	if name == "" {
+		return "", false, nil
+	}
+	unit, err := pi_launch_control.ParseMassUnit(name)
+	return unit, err == nil, err
+}
+
+// Sends the stored archive for a mission, with recorded masses in any ?unit= asked for.
+func serveMissionArchive(w http.ResponseWriter, r *http.Request, id string) {
+	archive, err := store.Archive(id)
+	if err == pi_launch_control.ErrMissionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Mission Not Found"))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var f io.ReadSeeker
+	unit, convert, err := requestUnit(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if convert {
+		buf := new(bytes.Buffer)
+		if err = pi_launch_control.WriteArchiveIn(buf, archive, unit); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		f = bytes.NewReader(buf.Bytes())
+	} else {
+		file, err := os.Open(archive)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		defer file.Close()
+		f = file
+	}
+
+	w.Header().Add("Pragma", "public")
+	w.Header().Add("Expires", "0")
+	w.Header().Add("Cache-Control", "must-revalidate, post-check=0, pre-check=0")
+	w.Header().Add("Cache-Control", "public")
+	w.Header().Add("Content-type", "application/octet-stream")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", missionFilename(r, id)))
+	w.Header().Add("Content-Transfer-Encoding", "binary")
+	if size, err := f.Seek(0, io.SeekEnd); err == nil {
+		w.Header().Add("Content-Length", fmt.Sprintf("%d", size))
+		f.Seek(0, io.SeekStart)
+	}
+	io.Copy(w, f)
+}
+
+// Stored Mission History.
+//
+// GET /missions lists MissionSummary for every stored mission.
+// GET /missions/{id} returns the stored Mission, DELETE /missions/{id} removes it.
+// GET /missions/{id}/download returns the mission archive.
+// GET /missions/{id}/metadata returns the MissionMetadata, PUT /missions/{id}/metadata replaces it.
+// GET /missions/{id}/analysis returns the thrust curve analysis, of the raw scale counts with ?filtered=false.
+func MissionsControl(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Mission Storage Not Available"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		summaries, err := store.List()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(w).Encode(summaries)
+	case len(parts) == 2 && r.Method == "GET":
+		data, err := store.Mission(parts[1])
+		if err == pi_launch_control.ErrMissionNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Mission Not Found"))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.Write(data)
+	case len(parts) == 2 && r.Method == "DELETE":
+		err := store.Delete(parts[1])
+		if err == pi_launch_control.ErrMissionNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Mission Not Found"))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case len(parts) == 3 && parts[2] == "download" && r.Method == "GET":
+		serveMissionArchive(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "analysis" && r.Method == "GET":
+		result, err := store.Analysis(parts[1], r.URL.Query().Get("filtered") != "false")
+		if err == pi_launch_control.ErrMissionNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Mission Not Found"))
+			return
+		} else if err == analysis.ErrNoBurn {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - No Burn Recorded"))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	case len(parts) == 3 && parts[2] == "metadata" && (r.Method == "GET" || r.Method == "PUT"):
+		var err error
+		var metadata pi_launch_control.MissionMetadata
+		if r.Method == "PUT" {
+			if err = json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			// Keep the live mission in step if it's the one being edited.
+			if mission := currentMission(); mission != nil && mission.ID == parts[1] {
+				err = mission.SetMetadata(metadata)
+			} else {
+				err = store.SetMetadata(parts[1], metadata)
+			}
+		} else {
+			metadata, err = store.Metadata(parts[1])
+		}
+		if err == pi_launch_control.ErrMissionNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Mission Not Found"))
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(w).Encode(metadata)
+	case len(parts) <= 3:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Not Found"))
+	}
+}
+
+// Records every request to a control endpoint in the audit log.
+//
+// Status reads are skipped, though GETs which download data or change the scale are recorded.
+func audited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if audit == nil || (r.Method == "GET" && !auditedRead(r.URL.Path)) {
+			h(w, r)
+			return
+		}
+
+		entry := pi_launch_control.NewAuditEntry(r)
+		before := missionID()
+
+		sw := &statusWriter{ ResponseWriter: w, status: http.StatusOK }
+		h(sw, r)
+
+		// Starting a mission belongs to the new mission, aborting it to the one which was underway.
+		entry.Mission = missionID()
+		if entry.Mission == "" {
+			entry.Mission = before
+		}
+		entry.Status = sw.status
+		if err := audit.Record(entry); err != nil {
+			fmt.Println("Error recording audit entry:", err)
+		}
+
+		// The mission was saved as it ended, before this was recorded. Add it to the stored archive.
+		if before != "" && missionID() == "" && store != nil {
+			if err := store.RefreshAudit(before); err != nil && err != pi_launch_control.ErrMissionNotFound {
+				fmt.Println("Error updating mission audit:", err)
+			}
+		}
+	}
+}
+
+func auditedRead(path string) bool {
+	return strings.HasSuffix(path, "/download") || path == "/scale/tare" || path == "/scale/calibrate"
+}
+
+func currentMission() *pi_launch_control.Mission {
+	missionLock.Lock()
+	defer missionLock.Unlock()
+
+	return mission
+}
+
+// ID of the mission underway, or "" if there isn't one.
+func missionID() string {
+	mission := currentMission()
+	if mission == nil || mission.Finished() {
+		return ""
+	}
+	return mission.ID
+}
+
+// Captures the response status for the audit log.
+type statusWriter struct {
+	http.ResponseWriter
+	status		int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Audit Log.
+//
+// GET /audit returns AuditEntry filtered by ?since= and ?until= (unix nanoseconds), ?action= and ?mission=.
+func AuditControl(w http.ResponseWriter, r *http.Request) {
+	if audit == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - Audit Log Not Available"))
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("500 - Method Not Supported"))
+		return
+	}
+
+	var since, until int64
+	var err error
+	query := r.URL.Query()
+	if v := query.Get("since"); v != "" {
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if until, err = strconv.ParseInt(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(audit.Query(since, until, query.Get("action"), query.Get("mission")))
+}
+
 func redirectTLS(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "https://" + r.Host + r.RequestURI, http.StatusMovedPermanently)
 }
@@ -330,6 +1296,32 @@ func redirectTLS(w http.ResponseWriter, r *http.Request) {
 func main() {
 	var err error = nil
 
+	cert := flag.String("cert", "/etc/ssl/certs/pi-launch-control/cert.pem", "The certificate for this server.")
+	certkey := flag.String("key", "/etc/ssl/certs/pi-launch-control/key.pem", "The key for the server cert.")
+	simulate := flag.Bool("simulate", false, "Simulate every device, with a scripted motor that burns after the igniter fires.")
+	simulateScale := flag.Bool("simulate-scale", false, "Use a simulated scale instead of the IIO load cell.")
+	simulateIgniter := flag.Bool("simulate-igniter", false, "Use a simulated igniter instead of the GPIO igniter circuit.")
+	cameraFrames := flag.String("camera-frames", "", "Loop the JPEG frames in this directory or mission .zip instead of using the camera.")
+	dataDir := flag.String("data", "/var/lib/pi-launch-control/missions", "Where completed missions are saved.")
+	flag.StringVar(&pi_launch_control.ScaleCalibrationDir, "calibration", pi_launch_control.ScaleCalibrationDir, "Where scale calibrations are saved. Empty to not save them.")
+	auditPath := flag.String("audit", "/var/lib/pi-launch-control/audit.log", "Where control actions are logged.")
+	armPin := flag.String("arm-pin", "GPIO22", "The GPIO the arming key switch is wired to.")
+	simulateCamera := flag.Bool("simulate-camera", false, "Use a generated test pattern instead of the camera.")
+	flag.DurationVar(&simMotor.Delay, "motor-delay", simMotor.Delay, "Simulated motor delay from igniter burn through to thrust.")
+	motorBurn := flag.Duration("motor-burn", simMotor.Curve.Duration(), "Simulated motor burn time.")
+	motorPeak := flag.Float64("motor-peak", 1200, "Simulated motor peak thrust, in calibration mass units.")
+	motorSustain := flag.Float64("motor-sustain", 450, "Simulated motor sustained thrust, in calibration mass units.")
+
+	flag.Parse()
+
+	simMotor.Curve = pi_launch_control.NewThrustCurve(*motorBurn, *motorPeak, *motorSustain)
+
+	if *simulate {
+		*simulateScale = true
+		*simulateIgniter = true
+		*simulateCamera = *cameraFrames == ""
+	}
+
 	// Create a channel for the scale and the camera triggers
 	scaleTrigC := make(chan time.Time, 1)
 	camTrigC   := make(chan time.Time, 1)
@@ -350,7 +1342,11 @@ func main() {
 	// Initialize the Scale.
 	scaleDevice := "/sys/devices/platform/weight@0"
 	scaleTrigger := "/sys/bus/iio/devices/iio_sysfs_trigger/trigger0"
-	scale, err = pi_launch_control.NewScale(scaleDevice, scaleTrigC, scaleTrigger);
+	if *simulateScale {
+		scale, err = newSimulatedScale(scaleTrigC)
+	} else {
+		scale, err = pi_launch_control.NewScale(scaleDevice, scaleTrigC, scaleTrigger);
+	}
 	if err != nil {
 		fmt.Println(err)
 		fmt.Println("Scale not Initialized: ", err)
@@ -361,7 +1357,19 @@ func main() {
 	}
 
 	// Initialize the Igniter.
-	igniter, err = pi_launch_control.NewIgniter("GPIO17", "GPIO27")
+	if *simulateIgniter {
+		simIgniter = pi_launch_control.NewSimulatedIgniter(pi_launch_control.DefaultSimulatedIgniterConfig())
+		igniter, err = simIgniter.NewIgniter()
+
+		// Light the scripted motor when the igniter burns through.
+		simIgniter.OnBurnThrough(func(t time.Time) {
+			if simScale != nil {
+				simMotor.Ignite(simScale, t)
+			}
+		})
+	} else {
+		igniter, err = pi_launch_control.NewIgniter("GPIO17", "GPIO27")
+	}
 	if err != nil {
 		fmt.Println(err)
 		fmt.Println("Igniter not Initialized: ", err)
@@ -370,8 +1378,32 @@ func main() {
 		fmt.Println("Igniter Initialized")
 	}
 
+	// Initialize Arming. Without it, the igniter will never fire.
+	if *simulateIgniter {
+		arming, err = simIgniter.NewArming()
+	} else {
+		arming, err = pi_launch_control.NewArming(*armPin)
+	}
+	if err != nil {
+		fmt.Println("Arming not Initialized: ", err)
+		arming = nil
+	} else {
+		arming.AddListener(broker.Outgoing)
+		if igniter != nil {
+			igniter.SetArming(arming)
+		}
+		fmt.Println("Arming Initialized")
+	}
+
 	// Initialize the Camera
-	camera, err = pi_launch_control.NewCamera("/dev/video0", camTrigC)
+	if *cameraFrames != "" {
+		camera, err = pi_launch_control.NewFileCamera(*cameraFrames, camTrigC)
+	} else if *simulateCamera {
+		camera, err = pi_launch_control.NewCameraFromSource(pi_launch_control.TestPatternCameraDevice,
+			pi_launch_control.NewTestPatternFrameSource(640, 480), camTrigC)
+	} else {
+		camera, err = pi_launch_control.NewCamera("/dev/video0", camTrigC)
+	}
 	if err != nil {
 		fmt.Println("Camera not Initialized: ", err)
 	} else {
@@ -385,15 +1417,41 @@ func main() {
 	// Go func to send to both of them when devicePoller ticks
 	go func() {
 		for t := range devicePoller.C {
-			if scale != nil && scale.Initialized {
+			if scale != nil && scale.IsInitialized() {
 				scaleTrigC <- t
 			}
-			if camera != nil && camera.Initialized {
+			if camera != nil && camera.IsInitialized() {
 				camTrigC <- t
 			}
 		}
 	}()
 
+	// Setup mission storage.
+	store, err = pi_launch_control.NewMissionStore(*dataDir)
+	if err != nil {
+		fmt.Println("Mission Storage not Available: ", err)
+		store = nil
+	} else {
+		store.Progress = func(id string, total int, complete int, err error) {
+			sendMissionPacking(total, complete, err)
+		}
+		fmt.Println("Saving missions to", *dataDir)
+	}
+	preflight = pi_launch_control.NewPreflight(store)
+
+	// Setup the audit log.
+	audit, err = pi_launch_control.NewAuditLog(*auditPath)
+	if err != nil {
+		fmt.Println("Audit Log not Available: ", err)
+		audit = nil
+	} else {
+		audit.AddListener(broker.Outgoing)
+		if store != nil {
+			store.Audit = audit
+		}
+		fmt.Println("Logging control actions to", *auditPath)
+	}
+
 	// Setup no initial Mission
 	mission = nil
 
@@ -410,21 +1468,37 @@ func main() {
 
 	http.HandleFunc("/igniter", IgniterControl)
 
+	http.HandleFunc("/arming", audited(ArmingControl))
+	http.HandleFunc("/arming/", audited(ArmingControl))
+
 	http.HandleFunc("/camera", camera.ServeHTTP)
 	http.HandleFunc("/camera/status", CameraStatusControl)
 
-	http.HandleFunc("/clock", ClockControl)
+	http.HandleFunc("/clock", audited(ClockControl))
 
-	http.HandleFunc("/scale", ScaleSettingsControl)
-	http.HandleFunc("/scale/tare", TareScaleControl)
-	http.HandleFunc("/scale/calibrate", CalibrateScaleControl)
+	http.HandleFunc("/scale", audited(ScaleSettingsControl))
+	http.HandleFunc("/scale/tare", audited(TareScaleControl))
+	http.HandleFunc("/scale/calibrate", audited(CalibrateScaleControl))
+	http.HandleFunc("/scale/calibration", audited(ScaleCalibrationControl))
+	http.HandleFunc("/scale/channel", audited(ScaleChannelControl))
+	http.HandleFunc("/scale/filter", audited(ScaleFilterControl))
 
-	http.HandleFunc("/mission/", MissionControl)
+	http.HandleFunc("/preflight", PreflightControl)
 
-	cert := flag.String("cert", "/etc/ssl/certs/pi-launch-control/cert.pem", "The certificate for this server.")
-	certkey := flag.String("key", "/etc/ssl/certs/pi-launch-control/key.pem", "The key for the server cert.")
+	http.HandleFunc("/audit", AuditControl)
 
-	flag.Parse()
+	http.HandleFunc("/mission/", audited(MissionControl))
+
+	http.HandleFunc("/missions", audited(MissionsControl))
+	http.HandleFunc("/missions/", audited(MissionsControl))
+
+	http.HandleFunc("/replay", audited(ReplayControl))
+	http.HandleFunc("/replay/", audited(ReplayControl))
+
+	if simScale != nil || simIgniter != nil {
+		fmt.Println("Simulation controls enabled.")
+		http.HandleFunc("/simulate/", audited(SimulationControl))
+	}
 
 	_, certerr := os.Stat(*cert)
 	_, keyerr := os.Stat(*certkey)